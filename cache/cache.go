@@ -0,0 +1,952 @@
+package cache
+
+import (
+	"sync"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/smart-cache/smart-cache-go/eviction"
+	"github.com/smart-cache/smart-cache-go/markov"
+	"github.com/smart-cache/smart-cache-go/datastore"
+	"github.com/smart-cache/smart-cache-go/config"
+)
+
+var ErrClosed = errors.New("cache is closed")
+var ErrTooLarge = errors.New("file is larger than the cache")
+var ErrNoChain = errors.New("prefetcher is not a markov chain")
+
+type Params[V any] struct {
+	Prefetch	config.PrefetchType				// NoPrefetch | MarkovPrefetch
+	Prefetcher	Prefetcher						// custom prefetcher, overrides Prefetch if set
+	Markov		markov.Options					// how MarkovPrefetch forgets old transitions and weighs clients
+	PrefetchShare	float64						// share of bytes and entries for unused prefetched files, PREFETCH_SHARE if 0
+	PrefetchThreshold	float64					// least probability of a prefetched file, for ThresholdPrefetchers
+	Snapshot	string							// markov chain snapshot file to warm-start MarkovPrefetch from, if any
+	PrefetchWorkers	int							// goroutines prefetching, PREFETCH_WORKERS if 0
+	PrefetchQueue	int							// prefetches waiting for a worker, PREFETCH_QUEUE if 0
+	PrefetchDrop	config.DropPolicy			// which prefetch a full queue drops, DropOldest by default
+	Eviction	config.EvictionType				// EvictLRU | EvictLFU | EvictARC | Evict2Q | EvictWTinyLFU
+	MaxBytes	int64							// capacity of the cache in bytes, CACHE_BYTES if 0
+	MaxEntries	int64							// maximum number of cached files, 0 for no limit
+	Sizer		func(V) int64					// size of a value in bytes, SizeOf if nil
+	DefaultTTL	time.Duration					// how long files stay cached, 0 to never expire
+	TTL			func(string, V) time.Duration	// per-file TTL (0 to never expire), DefaultTTL if nil
+	WriteMode	config.WriteMode				// WriteThrough | WriteBack
+	FlushInterval	time.Duration				// how often WriteBack caches flush, 0 to only flush on eviction
+}
+
+// a fetch from the datastore in progress, requests for the same file wait for it
+type flight[V any] struct {
+	done		chan struct{}					// closed once the fetch returned
+	prefetch	bool							// a prefetch, which only caches the file
+	stale		bool							// invalidated meanwhile, so the result isn't cached
+	value		V								// result of a request, for the requests waiting for it
+	err			error
+	picks		[]*pick[V]						// prefetches waiting for this request to pick their files
+}
+
+// the files a prefetch fetches, picked by the request it waits for once that request
+// cached (and evicted) what it does
+type pick[V any] struct {
+	candidates	[]string						// files the prefetcher predicted
+	filenames	[]string						// the candidates neither cached nor being fetched
+	flights		[]*flight[V]					// the prefetch's flights for filenames
+}
+
+// a cached value and the size it was charged when cached
+type entry[V any] struct {
+	value		V
+	size		int64
+	expires		time.Time						// zero if the entry never expires
+	dirty		bool							// written to the cache but not to the datastore yet
+	prefetched	bool							// prefetched and not requested yet (in probation)
+}
+
+func (e entry[V]) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+/********************************
+Cache supports the following external API to users
+MakeCache[V](id int, params Params[V], data datastore.Backend[V]) (* Cache[V])
+	Initializes a cache of values of type V in front of any backend
+	Files are evicted by params.Eviction, LRU by default, and prefetched by params.Prefetcher,
+	or params.Prefetch by default (NoPrefetch or MarkovPrefetch); any combination works
+	ThresholdPrefetchers (like MarkovPrefetch) only prefetch files predicted with at least
+	params.PrefetchThreshold probability, so unlikely files don't cost a datastore call
+	ClientPrefetchers (like MarkovPrefetch) prefetch what the requesting client is likely to
+	access next
+	Prefetched files wait in a probation segment, which holds at most params.PrefetchShare of
+	the cache, until they are first requested and join the rest of the cache
+	Prefetches run on params.PrefetchWorkers goroutines, waiting in a queue of at most
+	params.PrefetchQueue prefetches that drops them as params.PrefetchDrop says
+	Files are never fetched twice at once, requests for a file being fetched wait for it
+	and share the result, and the cache isn't locked while the datastore answers
+	Capacity is params.MaxBytes bytes (config.CACHE_BYTES by default), and at most
+	params.MaxEntries files if set
+	Values are sized with params.Sizer, or SizeOf by default
+	Files expire after params.TTL(filename, value), or params.DefaultTTL by default
+	The backend is shared with other caches, not copied, but calls to it are counted per cache
+c.Report() Stats
+	Get a report of the hits (split into demand and prefetch hits), misses, total calls
+	to the underlying datastore, the number of bytes currently cached, how many files were
+	prefetched, used and dropped unused, how long prefetches took, how many prefetches are
+	queued and were dropped, and how many files expired or were invalidated
+	TODO: Do we want a version number or timestamp mechanism of any form here?
+c.Fetch(filename string, clientID int) (V, error)
+	Specific client requests the `filename` file
+	Fails with datastore.ErrNotFound if the file does not exist
+	Files larger than the cache are returned but never cached
+	Expired files are dropped and fetched again
+c.Invalidate(filename string) bool
+	Drop `filename` from the cache, returns whether it was cached
+c.Put(filename string, value V) error
+	Write `value` to `filename`
+	WriteThrough caches write the datastore first, WriteBack caches mark the file
+	dirty and write it to the datastore when it is evicted, expires, is invalidated,
+	or is flushed (every params.FlushInterval, on Flush and on Close)
+	Fails with datastore.ErrReadOnly if the datastore can't be written to
+c.Refresh(filename string, value V) error
+	Replace the cached copy of `filename` without writing the datastore
+	(for replicas of a file that was already written elsewhere)
+c.Flush() error
+	Write every dirty file to the datastore
+c.Predict(filename string, n int) ([]string, error)
+	Predict the next n files to be accessed after `filename`, with the cache's prefetcher
+c.BatchPrefetch(filename string) error
+	Prefetch the files likely to be accessed after `filename` by any client
+c.BatchPrefetchClient(filename string, clientID int) error
+	Prefetch the files likely to be accessed after `clientID` accesses `filename`
+c.LocalChain() *markov.MarkovChain
+	Get a copy of the transitions observed by this cache alone (for syncing)
+	Empty unless the prefetcher is a markov chain
+c.LocalChanges() *markov.MarkovChain
+	Get the changes to the local chain since the last call, so syncs only ship changes
+	Merging every result into one chain keeps it equal to the local chain
+c.SyncChain(aggregate *markov.MarkovChain)
+	Replace the prediction model with an aggregate built across caches
+	Does nothing unless the prefetcher is a markov chain
+c.SaveSnapshot(path string) error
+	Save the prediction model to a file (JSON if path ends in .json, binary otherwise)
+c.LoadSnapshot(path string) error
+	Add the transitions of a saved snapshot to the prediction model, which MakeCache does
+	with params.Snapshot if it can be loaded (starting cold and counting SnapshotErrors
+	otherwise)
+	Loaded transitions are local changes the next sync ships, except those of params.Snapshot
+	Both fail with ErrNoChain unless the prefetcher is a markov chain
+c.Close() error
+	Take the cache down, every later Fetch fails with ErrClosed
+	Flushes dirty files first, drops queued prefetches and waits for running ones
+*********************************/
+type Cache[V any] struct {
+	mu          sync.Mutex          			// Lock to protect shared access to cache
+	cache	    map[string]entry[V]				// cached data storage
+	policy		eviction.Policy					// chooses which files to evict
+	probation	*eviction.LRU					// prefetched files not requested yet, oldest evicted first
+	prefetchShare	float64						// share of the cache probation may take
+	prefetchThreshold	float64					// least probability of a prefetched file
+	prefetchBytes	int64						// bytes of the files in probation
+	prefetches	*prefetchPool					// runs prefetches in the background, nil for NoPrefetcher
+	inflight	map[string]*flight[V]			// files being fetched from the datastore
+	timestamp	int64 							// number of accesses, for scheduling prefetches
+	maxBytes	int64							// maximum allowable cache size in bytes
+	maxEntries	int64							// maximum allowable number of files, 0 for no limit
+	bytes		int64							// current cache size in bytes
+	prefetcher	Prefetcher						// chooses which files to prefetch
+	local		*markov.MarkovChain				// transitions seen by this cache only, shared by syncing (markov prefetchers only)
+	shipped		*markov.MarkovChain				// local as of the last LocalChanges
+	data		datastore.Backend[V]			// for fetching data
+	sizer		func(V) int64					// size of values in bytes
+	defaultTTL	time.Duration					// TTL of files when ttl is nil
+	ttl			func(string, V) time.Duration	// per-file TTL
+	writeMode	config.WriteMode
+
+	closed		bool							// set by Close, cache refuses requests
+	done		chan struct{}					// closed by Close to stop background work
+
+	// external data
+	id          int								// uid for each cache (provided by ctor)
+	stats		Stats							// counters, Bytes is filled in by Report
+}
+
+// data is shared, so memory stays proportional to the cache's capacity
+func MakeCache[V any](id int, params Params[V], data datastore.Backend[V]) (* Cache[V]) {
+	sizer := params.Sizer
+	if sizer == nil {
+		sizer = SizeOf[V]
+	}
+	cache := &Cache[V]{
+		// set user provided vars
+		id: id,
+		maxBytes: params.MaxBytes,
+		maxEntries: params.MaxEntries,
+		data: data,
+		sizer: sizer,
+		defaultTTL: params.DefaultTTL,
+		ttl: params.TTL,
+		writeMode: params.WriteMode,
+
+		// set type defined vars
+		bytes: 0,
+		cache: make(map[string]entry[V]),
+		timestamp: 0,
+		done: make(chan struct{}),
+
+		// set special datatypes
+		policy: eviction.MakePolicy(params.Eviction),
+		probation: eviction.MakeLRU(),
+		prefetchShare: params.PrefetchShare,
+		prefetchThreshold: params.PrefetchThreshold,
+		prefetcher: params.Prefetcher,
+		inflight: make(map[string]*flight[V]),
+	}
+	if cache.prefetcher == nil {
+		cache.prefetcher = MakePrefetcher(params.Prefetch, params.Markov)
+	}
+	if cache.maxBytes <= 0 {
+		cache.maxBytes = config.CACHE_BYTES
+	}
+	if cache.prefetchShare <= 0 {
+		cache.prefetchShare = config.PREFETCH_SHARE
+	}
+	if chain, ok := cache.prefetcher.(*markov.MarkovChain); ok {
+		// forgets the same way, so syncing doesn't bring old transitions back
+		// client chains are never synced, so the local chain doesn't need them
+		opts := chain.Options()
+		opts.ClientWeight = 0
+		cache.local = markov.MakeMarkovChainWithOptions(opts)
+		cache.shipped = markov.MakeMarkovChain()
+	}
+	if params.Snapshot != "" {
+		// a missing or bad snapshot only costs what it would have taught, the cache starts
+		// cold and says so in its stats
+		if err := cache.LoadSnapshot(params.Snapshot); err != nil {
+			cache.stats.SnapshotErrors++
+		} else {
+			// the cache master warm-starts the aggregate itself, so the snapshot isn't shipped
+			cache.shipped = cache.local.Copy()
+		}
+	}
+
+	// caches that never prefetch don't need workers
+	if _, none := cache.prefetcher.(NoPrefetcher); !none {
+		workers, queue := params.PrefetchWorkers, params.PrefetchQueue
+		if workers <= 0 {
+			workers = config.PREFETCH_WORKERS
+		}
+		if queue <= 0 {
+			queue = config.PREFETCH_QUEUE
+		}
+		cache.prefetches = makePrefetchPool(workers, queue, params.PrefetchDrop, func(job prefetchJob) {
+			cache.BatchPrefetchClient(job.filename, job.clientID)
+		})
+	}
+
+	if cache.writeMode == config.WriteBack && params.FlushInterval > 0 {
+		go cache.flushDirty(params.FlushInterval)
+	}
+	return cache
+}
+
+func (cache *Cache[V]) Fetch(filename string, clientID int) (V, error) {
+	// a closed cache records nothing, so its next delta doesn't ship this fetch
+	cache.mu.Lock()
+	closed := cache.closed
+	cache.mu.Unlock()
+	if closed {
+		var zero V
+		return zero, ErrClosed
+	}
+
+	// inform the prefetcher of this transaction before taking the lock, so
+	// recording it never waits for other fetches (prefetchers lock themselves)
+	cache.prefetcher.Observe(filename, clientID)
+	if cache.local != nil {
+		cache.local.RecordTransition(filename, clientID)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.closed {
+		var zero V
+		return zero, ErrClosed
+	}
+
+	cache.timestamp++
+	// TODO: may want to change the ordering of the prefetching
+	if cache.prefetches != nil && cache.timestamp % config.PREFETCH_SIZE == 0 {
+		defer cache.prefetches.submit(prefetchJob{filename, clientID})
+	}
+
+	for {
+		cached, ok := cache.cache[filename]
+		if ok && cached.expired(time.Now()) {
+			// stale, treat as a miss
+			if err := cache.dropFile(filename); err != nil {
+				var zero V
+				return zero, err
+			}
+			cache.stats.Expirations++
+			ok = false
+		}
+
+		if ok {
+			// inform the eviction policy, misses are added to it once fetched
+			if cached.prefetched {
+				cache.promote(filename)
+				cache.stats.PrefetchHits++
+			} else {
+				cache.policy.Access(filename)
+				cache.stats.DemandHits++
+			}
+			cache.stats.Hits++
+			return cached.value, nil
+		}
+
+		f, inflight := cache.inflight[filename]
+		if !inflight {
+			break
+		}
+		// being fetched already, wait for it rather than fetching it twice
+		if f.prefetch {
+			cache.stats.PrefetchWaits++
+		} else {
+			cache.stats.Coalesced++
+		}
+		cache.mu.Unlock()
+		<-f.done
+		cache.mu.Lock()
+		if cache.closed {
+			var zero V
+			return zero, ErrClosed
+		}
+		if !f.prefetch {
+			// the same result, even if it couldn't be cached
+			cache.stats.Misses++
+			return f.value, f.err
+		}
+		// prefetches only cache files, so look again
+	}
+
+	file, err := cache.AddFileToCache(filename)
+	cache.stats.Misses++
+	return file, err
+}
+
+// takes the cache down, flushing dirty files
+func (cache *Cache[V]) Close() error {
+	cache.mu.Lock()
+	if cache.closed {
+		cache.mu.Unlock()
+		return nil
+	}
+	cache.closed = true
+	close(cache.done)
+	err := cache.flush()
+	cache.mu.Unlock()
+
+	// running prefetches find the cache closed, so waiting for them is short
+	if cache.prefetches != nil {
+		cache.prefetches.close()
+	}
+	return err
+}
+
+// drops filename from the cache so the next Fetch goes to the datastore
+// a dirty file is written to the datastore first
+func (cache *Cache[V]) Invalidate(filename string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if f, ok := cache.inflight[filename]; ok {
+		// what is being fetched may already be out of date
+		f.stale = true
+	}
+	if _, ok := cache.cache[filename]; !ok {
+		return false
+	}
+	if err := cache.dropFile(filename); err != nil {
+		return false
+	}
+	cache.stats.Invalidations++
+	return true
+}
+
+func (cache *Cache[V]) Put(filename string, value V) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.closed {
+		return ErrClosed
+	}
+	cache.timestamp++
+
+	if cache.writeMode == config.WriteThrough {
+		if err := cache.write(filename, value); err != nil {
+			return err
+		}
+		return cache.install(filename, value, false)
+	}
+
+	err := cache.install(filename, value, true)
+	if errors.Is(err, ErrTooLarge) {
+		// can't hold it until it is flushed, so write it now
+		return cache.write(filename, value)
+	}
+	return err
+}
+
+func (cache *Cache[V]) Refresh(filename string, value V) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.closed {
+		return ErrClosed
+	}
+	cache.timestamp++
+
+	err := cache.install(filename, value, false)
+	if errors.Is(err, ErrTooLarge) {
+		// the old copy is gone, so nothing stale is left behind
+		return nil
+	}
+	return err
+}
+
+func (cache *Cache[V]) Flush() error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.flush()
+}
+
+// flushes dirty files every interval until the cache is closed
+func (cache *Cache[V]) flushDirty(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cache.done:
+			return
+		case <-ticker.C:
+			// failed files stay dirty and are retried next time
+			cache.Flush()
+		}
+	}
+}
+
+func (cache *Cache[V]) Report() Stats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	stats := cache.stats
+	stats.Bytes = cache.bytes
+	if cache.prefetches != nil {
+		stats.PrefetchQueueDepth, stats.PrefetchDropped = cache.prefetches.stats()
+	}
+	return stats
+}
+
+// predict the next n files after filename is accessed
+func (cache *Cache[V]) Predict(filename string, n int) ([]string, error) {
+	return cache.prefetcher.Predict(filename, n)
+}
+
+// returns a copy of the transitions observed by this cache alone
+func (cache *Cache[V]) LocalChain() *markov.MarkovChain {
+	if cache.local == nil {
+		return markov.MakeMarkovChain()
+	}
+	return cache.local.Copy()
+}
+
+// returns the changes to the transitions observed by this cache alone since the last call
+// (see markov.Diff), keeping a copy of the local chain to compare the next call with
+func (cache *Cache[V]) LocalChanges() *markov.MarkovChain {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.local == nil {
+		return markov.MakeMarkovChain()
+	}
+	current := cache.local.Copy()
+	changes := current.Diff(cache.shipped)
+	cache.shipped = current
+	return changes
+}
+
+// replaces the prediction model with the aggregate built by the cache master
+// local transitions are kept, so the next sync still includes everything this cache saw
+func (cache *Cache[V]) SyncChain(aggregate *markov.MarkovChain) {
+	if chain, ok := cache.prefetcher.(*markov.MarkovChain); ok {
+		chain.Rebase(aggregate)
+	}
+}
+
+// writes the prediction model to path
+func (cache *Cache[V]) SaveSnapshot(path string) error {
+	chain, ok := cache.prefetcher.(*markov.MarkovChain)
+	if !ok {
+		return ErrNoChain
+	}
+	return chain.SaveFile(path)
+}
+
+// adds the transitions saved at path to the prediction model, keeping what this cache observed
+// the counts are added to the local chain too, so the next sync ships them to every cache
+// and a sync doesn't undo the load
+func (cache *Cache[V]) LoadSnapshot(path string) error {
+	chain, ok := cache.prefetcher.(*markov.MarkovChain)
+	if !ok {
+		return ErrNoChain
+	}
+	snapshot, err := markov.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	chain.Merge(snapshot)
+	cache.local.Merge(snapshot)
+	return nil
+}
+
+func (cache *Cache[V]) BatchPrefetch (filename string) error {
+	return cache.prefetchFiles(cache.prefetchCandidates(filename))
+}
+
+func (cache *Cache[V]) BatchPrefetchClient(filename string, clientID int) error {
+	return cache.prefetchFiles(cache.clientCandidates(filename, clientID))
+}
+
+// fetches the files that are neither cached nor being fetched already, without holding
+// the lock while the datastore answers
+func (cache *Cache[V]) prefetchFiles(files []string, err error) error {
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	cache.mu.Lock()
+	if cache.closed {
+		cache.mu.Unlock()
+		return ErrClosed
+	}
+	var filenames []string
+	var flights []*flight[V]
+	if f := cache.requested(files); f != nil {
+		// what the request caches and evicts changes what is worth prefetching,
+		// so it picks the files once it is done
+		p := &pick[V]{candidates: files}
+		f.picks = append(f.picks, p)
+		cache.mu.Unlock()
+		<-f.done
+		cache.mu.Lock()
+		filenames, flights = p.filenames, p.flights
+	} else {
+		filenames, flights = cache.claim(files)
+	}
+	closed := cache.closed
+	cache.mu.Unlock()
+	if closed || len(filenames) == 0 {
+		cache.land(filenames, flights)
+		if closed {
+			return ErrClosed
+		}
+		return nil
+	}
+
+	start := time.Now()
+	fetched, err := cache.data.GetBatch(filenames)
+	latency := time.Since(start)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	defer cache.landed(filenames, flights)
+	if cache.closed {
+		return ErrClosed
+	}
+	return cache.addBatch(filenames, fetched, err, latency)
+}
+
+// assumes lock on cache.mu is held
+// starts fetching the candidates that are neither cached nor being fetched
+func (cache *Cache[V]) claim(candidates []string) ([]string, []*flight[V]) {
+	filenames := cache.uncached(candidates)
+	flights := make([]*flight[V], len(filenames))
+	for i, filename := range filenames {
+		flights[i] = &flight[V]{done: make(chan struct{}), prefetch: true}
+		cache.inflight[filename] = flights[i]
+	}
+	return filenames, flights
+}
+
+// assumes lock on cache.mu is held
+// a request fetching one of files from the datastore, nil if there is none
+func (cache *Cache[V]) requested(files []string) *flight[V] {
+	for _, filename := range files {
+		if f, ok := cache.inflight[filename]; ok && !f.prefetch {
+			return f
+		}
+	}
+	return nil
+}
+
+// assumes lock on cache.mu is held
+// requests waiting for these files find them cached, or fetch them themselves
+func (cache *Cache[V]) landed(filenames []string, flights []*flight[V]) {
+	for i, filename := range filenames {
+		delete(cache.inflight, filename)
+		close(flights[i].done)
+	}
+}
+
+// landed, taking the lock
+func (cache *Cache[V]) land(filenames []string, flights []*flight[V]) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.landed(filenames, flights)
+}
+
+// the files worth prefetching after filename
+// unlikely files are left out if the prefetcher knows how likely they are
+func (cache *Cache[V]) prefetchCandidates(filename string) ([]string, error) {
+	prefetcher, ok := cache.prefetcher.(ThresholdPrefetcher)
+	if !ok || cache.prefetchThreshold <= 0 {
+		return cache.prefetcher.Predict(filename, config.PREFETCH_SIZE)
+	}
+	return predictionNames(prefetcher.PredictProbable(filename, config.PREFETCH_SIZE, cache.prefetchThreshold))
+}
+
+// the files worth prefetching after clientID accesses filename
+func (cache *Cache[V]) clientCandidates(filename string, clientID int) ([]string, error) {
+	prefetcher, ok := cache.prefetcher.(ClientPrefetcher)
+	if !ok {
+		return cache.prefetchCandidates(filename)
+	}
+	return predictionNames(prefetcher.PredictClient(filename, clientID, config.PREFETCH_SIZE, cache.prefetchThreshold))
+}
+
+func predictionNames(predictions []markov.Prediction, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(predictions))
+	for i, prediction := range predictions {
+		files[i] = prediction.Name
+	}
+	return files, nil
+}
+
+// assumes lock on cache.mu is held, and releases it while the datastore answers
+// requests for filename meanwhile wait for this one and share its result
+func (cache *Cache[V]) AddFileToCache(filename string) (V, error) {
+	if cached, ok := cache.cache[filename]; ok {
+		return cached.value, nil
+	}
+
+	f := &flight[V]{done: make(chan struct{})}
+	cache.inflight[filename] = f
+	cache.mu.Unlock()
+	file, err := cache.data.Get(filename)
+	cache.mu.Lock()
+	cache.stats.Calls++
+	delete(cache.inflight, filename)
+	defer func() {
+		// prefetches waiting for this request pick their files now that it is cached
+		for _, p := range f.picks {
+			if !cache.closed {
+				p.filenames, p.flights = cache.claim(p.candidates)
+			}
+		}
+		close(f.done)
+	}()
+
+	if err != nil {
+		f.err = fmt.Errorf("cache %d failed to fetch file: %w", cache.id, err)
+		return file, f.err
+	}
+	f.value = file
+	if cached, ok := cache.cache[filename]; ok {
+		// written meanwhile, which is newer than what was fetched
+		f.value = cached.value
+	} else if !f.stale && !cache.closed {
+		// fill the cache with this new datatype
+		// files too large to cache are still served
+		cache.AddFile(filename, file)
+	}
+	return f.value, nil
+}
+
+// default number of bytes a value takes up in the cache
+// length for strings and byte slices, Size() for values that know their size,
+// and the shallow size of the value otherwise
+func SizeOf[V any](value V) int64 {
+	switch v := any(value).(type) {
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case interface{ Size() int64 }:
+		return v.Size()
+	}
+	if t := reflect.TypeOf(value); t != nil {
+		return int64(t.Size())
+	}
+	return 0
+}
+
+// assumes lock on cache.mu is held
+// evicts as many files as needed to make room, fails with ErrTooLarge if file can never fit
+func (cache *Cache[V]) AddFile(filename string, file V) error {
+	return cache.addFile(filename, file, false)
+}
+
+// assumes lock on cache.mu is held
+// replaces any cached copy of filename, which is dropped even if file does not fit
+func (cache *Cache[V]) install(filename string, file V, dirty bool) error {
+	err := cache.addFile(filename, file, dirty)
+	if errors.Is(err, ErrTooLarge) {
+		if _, ok := cache.cache[filename]; ok {
+			// the old copy is being replaced, so it doesn't need flushing
+			cache.removeFile(filename)
+		}
+	}
+	return err
+}
+
+// assumes lock on cache.mu is held
+func (cache *Cache[V]) addFile(filename string, file V, dirty bool) error {
+	size := cache.sizer(file)
+	if size > cache.maxBytes {
+		return fmt.Errorf("%w: %v is %d bytes, cache holds %d", ErrTooLarge, filename, size, cache.maxBytes)
+	}
+
+	if old, ok := cache.cache[filename]; ok {
+		cache.bytes -= old.size
+		if old.prefetched {
+			// replaced before it was used, it joins the main segment as a new file
+			cache.probation.Remove(filename)
+			cache.prefetchBytes -= old.size
+		}
+	}
+	cache.cache[filename] = entry[V]{file, size, cache.expiry(filename, file), dirty, false}
+	cache.bytes += size
+	cache.policy.Add(filename)
+
+	for cache.bytes > cache.maxBytes || (cache.maxEntries > 0 && int64(len(cache.cache)) > cache.maxEntries) {
+		// need to evict, so let the policy choose a victim
+		// unused prefetches are bounded by their share, so they only go once the main segment is empty
+		evict, ok := cache.policy.Evict()
+		if !ok {
+			if evict, ok = cache.probation.Evict(); !ok {
+				break
+			}
+		}
+		if err := cache.dropFile(evict); err != nil {
+			// keep the dirty file, which is never a prefetched one
+			cache.policy.Add(evict)
+			if evict != filename {
+				cache.removeFile(filename)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// assumes lock on cache.mu is held
+// caches a file that was predicted rather than requested in probation
+// probation evicts its oldest files to stay within its share, but never the first
+// batchSize files of the batch this file belongs to, in which case this file is dropped
+// the main segment only loses files if it uses more than the rest of the cache
+func (cache *Cache[V]) addPrefetched(filename string, file V, batchSize int) error {
+	size := cache.sizer(file)
+	maxBytes, maxEntries := cache.probationCapacity()
+	if size > maxBytes {
+		return fmt.Errorf("%w: %v is %d bytes, prefetches get %d", ErrTooLarge, filename, size, maxBytes)
+	}
+
+	for cache.prefetchBytes + size > maxBytes || (maxEntries > 0 && int64(cache.probation.Len()) >= maxEntries) {
+		if cache.probation.Len() <= batchSize {
+			// only this batch is left, and it is never evicted to make room for itself
+			return nil
+		}
+		evict, _ := cache.probation.Evict()
+		cache.removeFile(evict)
+	}
+
+	for cache.bytes + size > cache.maxBytes || (cache.maxEntries > 0 && int64(len(cache.cache)) >= cache.maxEntries) {
+		evict, ok := cache.policy.Evict()
+		if !ok {
+			return nil
+		}
+		if err := cache.dropFile(evict); err != nil {
+			cache.policy.Add(evict)
+			return err
+		}
+	}
+
+	cache.cache[filename] = entry[V]{file, size, cache.expiry(filename, file), false, true}
+	cache.bytes += size
+	cache.prefetchBytes += size
+	cache.probation.Add(filename)
+	cache.stats.Prefetched++
+	return nil
+}
+
+// bytes and entries probation can hold, no entry limit if the cache has none
+func (cache *Cache[V]) probationCapacity() (int64, int64) {
+	maxBytes := int64(float64(cache.maxBytes) * cache.prefetchShare)
+	var maxEntries int64
+	if cache.maxEntries > 0 {
+		maxEntries = int64(float64(cache.maxEntries) * cache.prefetchShare)
+		if maxEntries < 1 {
+			maxEntries = 1
+		}
+	}
+	return maxBytes, maxEntries
+}
+
+// assumes lock on cache.mu is held
+// moves a prefetched file into the main segment on its first request
+func (cache *Cache[V]) promote(filename string) {
+	cached := cache.cache[filename]
+	cached.prefetched = false
+	cache.cache[filename] = cached
+	cache.probation.Remove(filename)
+	cache.prefetchBytes -= cached.size
+	cache.policy.Add(filename)
+}
+
+// assumes lock on cache.mu is held
+// writes value to the datastore
+func (cache *Cache[V]) write(filename string, value V) error {
+	writer, ok := cache.data.(datastore.Writer[V])
+	if !ok {
+		return fmt.Errorf("cache %d failed to write %v: %w", cache.id, filename, datastore.ErrReadOnly)
+	}
+	cache.stats.Calls++
+	if err := writer.Put(filename, value); err != nil {
+		return fmt.Errorf("cache %d failed to write %v: %w", cache.id, filename, err)
+	}
+	return nil
+}
+
+// assumes lock on cache.mu is held
+func (cache *Cache[V]) flush() error {
+	var err error
+	for filename, cached := range cache.cache {
+		if !cached.dirty {
+			continue
+		}
+		if werr := cache.write(filename, cached.value); werr != nil {
+			err = werr
+			continue
+		}
+		cached.dirty = false
+		cache.cache[filename] = cached
+	}
+	return err
+}
+
+// assumes lock on cache.mu is held
+// removes filename from the cache, writing it to the datastore first if dirty
+func (cache *Cache[V]) dropFile(filename string) error {
+	if cached := cache.cache[filename]; cached.dirty {
+		if err := cache.write(filename, cached.value); err != nil {
+			return err
+		}
+	}
+	cache.removeFile(filename)
+	return nil
+}
+
+// when a file cached now should expire, zero if never
+func (cache *Cache[V]) expiry(filename string, file V) time.Time {
+	ttl := cache.defaultTTL
+	if cache.ttl != nil {
+		ttl = cache.ttl(filename, file)
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// assumes lock on cache.mu is held
+func (cache *Cache[V]) removeFile(filename string) {
+	cached := cache.cache[filename]
+	cache.bytes -= cached.size
+	if cached.prefetched {
+		cache.probation.Remove(filename)
+		cache.prefetchBytes -= cached.size
+		cache.stats.EvictedUnused++
+	} else {
+		cache.policy.Remove(filename)
+	}
+	delete(cache.cache, filename)
+}
+
+// files that could be fetched are cached even if part of the batch is missing
+// files are cached as prefetched, files that are already cached or being fetched are left alone
+func (cache *Cache[V]) AddBatchToCache(predicted []string) (error) {
+	return cache.prefetchFiles(predicted, nil)
+}
+
+// assumes lock on cache.mu is held
+// the predicted files that are neither cached nor being prefetched, each once
+func (cache *Cache[V]) uncached(predicted []string) []string {
+	filenames := make([]string, 0, len(predicted))
+	seen := make(map[string]bool)
+	for _, filename := range predicted {
+		_, cached := cache.cache[filename]
+		_, inflight := cache.inflight[filename]
+		if !cached && !inflight && !seen[filename] {
+			seen[filename] = true
+			filenames = append(filenames, filename)
+		}
+	}
+	return filenames
+}
+
+// assumes lock on cache.mu is held
+// caches what fetching filenames as a batch returned, files cached meanwhile are left alone
+func (cache *Cache[V]) addBatch(filenames []string, files []V, err error, latency time.Duration) error {
+	cache.stats.Calls++
+	cache.stats.PrefetchBatches++
+	cache.stats.PrefetchesIssued += int64(len(filenames))
+	cache.stats.PrefetchLatency += latency
+
+	missing := make(map[string]bool)
+	var partial *datastore.MissingError
+	if errors.As(err, &partial) {
+		for _, filename := range partial.Files {
+			missing[filename] = true
+		}
+	} else if err != nil {
+		return fmt.Errorf("cache %d failed to fetch batch: %w", cache.id, err)
+	}
+
+	added := 0
+	for i, filename := range filenames {
+		if missing[filename] {
+			continue
+		}
+		if _, ok := cache.cache[filename]; ok {
+			// requested or written while it was being fetched
+			continue
+		}
+		if f, ok := cache.inflight[filename]; ok && f.stale {
+			continue
+		}
+		// skip files too large to cache, the rest of the batch still fits
+		cache.addPrefetched(filename, files[i], added)
+		if _, ok := cache.cache[filename]; ok {
+			added++
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("cache %d failed to fetch batch: %w", cache.id, err)
+	}
+	return nil
+}
\ No newline at end of file
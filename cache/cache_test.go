@@ -0,0 +1,1447 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	// "reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"github.com/smart-cache/smart-cache-go/datastore"
+	"github.com/smart-cache/smart-cache-go/markov"
+	// "github.com/smart-cache/smart-cache-go/utils"
+	"github.com/smart-cache/smart-cache-go/config"
+)
+
+func TestBasicLRUFail(t *testing.T) {
+	fmt.Printf("TestBasicLRUFail ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+
+	// add files to datastore
+	for j := 0; j < (config.CACHE_SIZE + 1); j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+        data.Make(filename, filename)
+	}
+
+	id := 1
+	iter := 4 // number of iterations
+
+	// data is shared, not copied
+	cache := MakeCache(id, Params[string]{MaxBytes: config.CACHE_BYTES, MaxEntries: config.CACHE_SIZE}, data)
+
+	for i := 0; i < iter; i++ {
+		for j := 0; j < (config.CACHE_SIZE + 1); j++ {
+			filename := "fake_" + strconv.Itoa(j) + ".txt"
+			_, err := cache.Fetch(filename, id)
+			if err != nil {
+				t.Errorf("Could not open %s from cache", filename)
+				failed = true
+			}
+		}
+	}
+
+	stats := cache.Report()
+	hits, misses := stats.Hits, stats.Misses
+
+	expected_misses := (int64(iter) * (config.CACHE_SIZE + 1))
+	if hits != 0 || misses != expected_misses {
+		t.Errorf("Expected 0 hits and %d misses, got %d hits and %d misses.", expected_misses, hits, misses)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestBasicLRUSuccess(t *testing.T) {
+	fmt.Printf("TestBasicLRUSuccess ...\n")
+	failed := false
+	data := datastore.MakeDataStore[string]()
+
+	// add files to datastore
+	for j := 0; j < config.CACHE_SIZE; j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+        data.Make(filename, filename)
+	}
+
+	id := 1
+	iter := 4 // number of iterations
+
+	cache := MakeCache(id, Params[string]{MaxBytes: config.CACHE_BYTES, MaxEntries: config.CACHE_SIZE}, data)
+
+	if config.CACHE_SIZE > 100 {
+		fmt.Printf("\tignoring, CACHE_SIZE too big\n")
+		return
+	}
+
+	for i := 0; i < iter; i++ {
+		for j := 0; j < config.CACHE_SIZE; j++ {
+			filename := "fake_" + strconv.Itoa(j) + ".txt"
+			_, err := cache.Fetch(filename, id)
+			if err != nil {
+				t.Errorf("Could not open %s from cache", filename)
+				failed = true
+			}
+		}
+	}
+
+	stats := cache.Report()
+	hits, misses := stats.Hits, stats.Misses
+	expected_hits := (config.CACHE_SIZE * int64(iter - 1))
+
+	if hits != expected_hits || misses != config.CACHE_SIZE {
+		t.Errorf("Expected %d hits and %d misses, got %d hits and %d misses.", expected_hits, config.CACHE_SIZE, hits, misses)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestBasicMarkovSequential(t *testing.T) {
+	fmt.Printf("TestBasicMarkovSequential ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+
+	// add files to datastore
+	for j := 0; j < (config.CACHE_SIZE + 1); j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+        data.Make(filename, filename)
+	}
+
+	id := 1
+	iter := 4 // number of iterations
+
+	// data is shared, not copied
+	cache := MakeCache(id, Params[string]{Prefetch: config.MarkovPrefetch, MaxBytes: config.CACHE_BYTES, MaxEntries: config.CACHE_SIZE}, data)
+
+	for i := 0; i < iter; i++ {
+		for j := 0; j < (config.CACHE_SIZE + 1); j++ {
+			filename := "fake_" + strconv.Itoa(j) + ".txt"
+			_, err := cache.Fetch(filename, id)
+			if err != nil {
+				t.Errorf("Could not open %s from cache", filename)
+				failed = true
+			}
+		}
+	}
+
+	stats := cache.Report()
+	hits, misses := stats.Hits, stats.Misses
+
+	expected_misses := (int64(iter) * (config.CACHE_SIZE + 1))
+	if hits == 0 || misses >= expected_misses {
+		t.Errorf("Expected more than 0 hits and less than %d misses, got %d hits and %d misses.", expected_misses, hits, misses)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestFetchMissing(t *testing.T) {
+	fmt.Printf("TestFetchMissing ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	data.Make("fake_0.txt", "fake_0.txt")
+
+	id := 1
+	cache := MakeCache(id, Params[string]{Prefetch: config.MarkovPrefetch, MaxBytes: config.CACHE_BYTES, MaxEntries: config.CACHE_SIZE}, data)
+
+	// a missing file is an error, not a crash
+	if _, err := cache.Fetch("missing.txt", id); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+		failed = true
+	}
+
+	// and the cache keeps working afterwards
+	for i := 0; i < 2; i++ {
+		if file, err := cache.Fetch("fake_0.txt", id); err != nil || file != "fake_0.txt" {
+			t.Errorf("Could not open fake_0.txt from cache: %v", err)
+			failed = true
+		}
+	}
+
+	stats := cache.Report()
+	hits, misses := stats.Hits, stats.Misses
+	if hits != 1 || misses != 2 {
+		t.Errorf("Expected 1 hit and 2 misses, got %d hits and %d misses.", hits, misses)
+		failed = true
+	}
+
+	if _, err := cache.Predict("fake_0.txt", -1); err == nil {
+		t.Errorf("Expected an error predicting a negative number of files")
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestBatchMissing(t *testing.T) {
+	fmt.Printf("TestBatchMissing ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	data.Make("fake_0.txt", "fake_0.txt")
+	data.Make("fake_1.txt", "fake_1.txt")
+
+	id := 1
+	cache := MakeCache(id, Params[string]{MaxBytes: config.CACHE_BYTES, MaxEntries: config.CACHE_SIZE}, data)
+
+	err := cache.AddBatchToCache([]string{"fake_0.txt", "missing.txt", "fake_1.txt"})
+
+	var missing *datastore.MissingError
+	if !errors.As(err, &missing) || len(missing.Files) != 1 || missing.Files[0] != "missing.txt" {
+		t.Errorf("Expected missing.txt to be reported missing, got %v", err)
+		failed = true
+	}
+
+	// the files that were found are cached anyway
+	for _, filename := range []string{"fake_0.txt", "fake_1.txt"} {
+		if _, err := cache.Fetch(filename, id); err != nil {
+			t.Errorf("Could not open %s from cache: %v", filename, err)
+			failed = true
+		}
+	}
+	stats := cache.Report()
+	hits, misses := stats.Hits, stats.Misses
+	if hits != 2 || misses != 0 {
+		t.Errorf("Expected 2 hits and 0 misses, got %d hits and %d misses.", hits, misses)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestLoaderBackend(t *testing.T) {
+	fmt.Printf("TestLoaderBackend ...\n")
+	failed := false
+
+	loads := 0
+	load := func(filename string) (string, error) {
+		loads++
+		return "loaded " + filename, nil
+	}
+	data := datastore.MakeLoaderStore(load, []string{})
+
+	id := 1
+	cache := MakeCache(id, Params[string]{MaxBytes: config.CACHE_BYTES, MaxEntries: config.CACHE_SIZE}, data)
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < config.CACHE_SIZE; j++ {
+			filename := "fake_" + strconv.Itoa(j) + ".txt"
+			file, err := cache.Fetch(filename, id)
+			if err != nil || file != "loaded " + filename {
+				t.Errorf("Could not open %s from cache: %v", filename, err)
+				failed = true
+			}
+		}
+	}
+
+	stats := cache.Report()
+	hits, misses, calls := stats.Hits, stats.Misses, stats.Calls
+	if loads != config.CACHE_SIZE || calls != config.CACHE_SIZE {
+		t.Errorf("Expected %d loads, got %d loads and %d calls.", config.CACHE_SIZE, loads, calls)
+		failed = true
+	}
+	if hits != 2 * config.CACHE_SIZE || misses != config.CACHE_SIZE {
+		t.Errorf("Expected %d hits and %d misses, got %d hits and %d misses.", 2 * config.CACHE_SIZE, config.CACHE_SIZE, hits, misses)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestByteCapacity(t *testing.T) {
+	fmt.Printf("TestByteCapacity ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	data.Make("small_0", strings.Repeat("a", 40))
+	data.Make("small_1", strings.Repeat("b", 40))
+	data.Make("small_2", strings.Repeat("c", 40))
+	data.Make("medium", strings.Repeat("d", 90))
+	data.Make("huge", strings.Repeat("e", 200))
+
+	id := 1
+	cache := MakeCache(id, Params[string]{MaxBytes: 100}, data)
+
+	checkBytes := func(expected int64) {
+		if bytes := cache.Report().Bytes; bytes != expected {
+			t.Errorf("Expected %d bytes cached, got %d", expected, bytes)
+			failed = true
+		}
+	}
+	checkCached := func(filename string, expected bool) {
+		cache.mu.Lock()
+		_, ok := cache.cache[filename]
+		cache.mu.Unlock()
+		if ok != expected {
+			t.Errorf("Expected cached(%s) = %v", filename, expected)
+			failed = true
+		}
+	}
+
+	// two small files fit, the third evicts the least recently used
+	cache.Fetch("small_0", id)
+	cache.Fetch("small_1", id)
+	checkBytes(80)
+	cache.Fetch("small_2", id)
+	checkBytes(80)
+	checkCached("small_0", false)
+
+	// one medium file needs both remaining small files evicted
+	cache.Fetch("medium", id)
+	checkBytes(90)
+	checkCached("small_1", false)
+	checkCached("small_2", false)
+
+	// a file larger than the whole cache is served, but never cached
+	file, err := cache.Fetch("huge", id)
+	if err != nil || len(file) != 200 {
+		t.Errorf("Expected huge to be served, got %d bytes: %v", len(file), err)
+		failed = true
+	}
+	checkBytes(90)
+	checkCached("huge", false)
+	checkCached("medium", true)
+
+	cache.mu.Lock()
+	err = cache.AddFile("huge", file)
+	cache.mu.Unlock()
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+		failed = true
+	}
+
+	// the entry limit applies alongside the byte limit
+	cache = MakeCache(id, Params[string]{MaxBytes: 1000, MaxEntries: 2}, data)
+	cache.Fetch("small_0", id)
+	cache.Fetch("small_1", id)
+	cache.Fetch("small_2", id)
+	checkBytes(80)
+	checkCached("small_0", false)
+
+	// without a byte limit the cache holds CACHE_BYTES
+	cache = MakeCache(id, Params[string]{}, data)
+	cache.Fetch("huge", id)
+	checkBytes(200)
+	checkCached("huge", true)
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+type record struct {
+	name		string
+	payload		[]byte
+}
+
+func TestStructValues(t *testing.T) {
+	fmt.Printf("TestStructValues ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[record]()
+	for j := 0; j < 4; j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+		data.Make(filename, record{filename, make([]byte, 30)})
+	}
+
+	// records are charged for their payload
+	sizer := func(r record) int64 {
+		return int64(len(r.payload))
+	}
+
+	id := 1
+	cache := MakeCache(id, Params[record]{MaxBytes: 100, Sizer: sizer}, data)
+
+	for j := 0; j < 4; j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+		r, err := cache.Fetch(filename, id)
+		if err != nil || r.name != filename {
+			t.Errorf("Could not open %s from cache: %v", filename, err)
+			failed = true
+		}
+	}
+
+	// only three 30 byte payloads fit
+	if bytes := cache.Report().Bytes; bytes != 90 {
+		t.Errorf("Expected 90 bytes cached, got %d", bytes)
+		failed = true
+	}
+
+	if _, err := cache.Fetch("missing.txt", id); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+		failed = true
+	}
+
+	if SizeOf("abc") != 3 || SizeOf([]byte{1, 2}) != 2 || SizeOf(int64(7)) != 8 {
+		t.Errorf("Unexpected default sizes")
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	fmt.Printf("TestTTL ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	data.Make("short.txt", "short.txt")
+	data.Make("forever.txt", "forever.txt")
+
+	ttl := func(filename string, file string) time.Duration {
+		if filename == "forever.txt" {
+			return 0
+		}
+		return 20 * time.Millisecond
+	}
+
+	id := 1
+	cache := MakeCache(id, Params[string]{MaxBytes: config.CACHE_BYTES, TTL: ttl}, data)
+
+	for i := 0; i < 2; i++ {
+		cache.Fetch("short.txt", id)
+		cache.Fetch("forever.txt", id)
+	}
+	time.Sleep(30 * time.Millisecond)
+	cache.Fetch("short.txt", id)
+	cache.Fetch("forever.txt", id)
+
+	stats := cache.Report()
+	hits, misses := stats.Hits, stats.Misses
+	if hits != 3 || misses != 3 {
+		t.Errorf("Expected 3 hits and 3 misses, got %d hits and %d misses.", hits, misses)
+		failed = true
+	}
+	if expirations := cache.Report().Expirations; expirations != 1 {
+		t.Errorf("Expected 1 expiration, got %d", expirations)
+		failed = true
+	}
+
+	// the default TTL applies to everything when there is no TTL function
+	cache = MakeCache(id, Params[string]{MaxBytes: config.CACHE_BYTES, DefaultTTL: 20 * time.Millisecond}, data)
+	cache.Fetch("forever.txt", id)
+	time.Sleep(30 * time.Millisecond)
+	cache.Fetch("forever.txt", id)
+	if expirations := cache.Report().Expirations; expirations != 1 {
+		t.Errorf("Expected 1 expiration with a default TTL, got %d", expirations)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	fmt.Printf("TestInvalidate ...\n")
+	failed := false
+
+	version := "v1"
+	load := func(filename string) (string, error) {
+		return filename + "@" + version, nil
+	}
+	data := datastore.MakeLoaderStore(load, []string{})
+
+	id := 1
+	cache := MakeCache(id, Params[string]{MaxBytes: config.CACHE_BYTES}, data)
+
+	cache.Fetch("a.txt", id)
+	version = "v2"
+
+	// without invalidation the stale copy is served
+	if file, _ := cache.Fetch("a.txt", id); file != "a.txt@v1" {
+		t.Errorf("Expected the cached a.txt@v1, got %s", file)
+		failed = true
+	}
+
+	if !cache.Invalidate("a.txt") || cache.Invalidate("a.txt") || cache.Invalidate("b.txt") {
+		t.Errorf("Expected only the first invalidation of a.txt to drop it")
+		failed = true
+	}
+	if bytes := cache.Report().Bytes; bytes != 0 {
+		t.Errorf("Expected an empty cache, got %d bytes", bytes)
+		failed = true
+	}
+
+	if file, _ := cache.Fetch("a.txt", id); file != "a.txt@v2" {
+		t.Errorf("Expected a.txt@v2 after invalidation, got %s", file)
+		failed = true
+	}
+	if invalidations := cache.Report().Invalidations; invalidations != 1 {
+		t.Errorf("Expected 1 invalidation, got %d", invalidations)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	fmt.Printf("TestWriteThrough ...\n")
+	failed := false
+
+	dir := t.TempDir()
+	data := datastore.MakeDirStore[string](dir, datastore.StringCodec{})
+
+	id := 1
+	cache := MakeCache(id, Params[string]{MaxBytes: config.CACHE_BYTES, WriteMode: config.WriteThrough}, data)
+
+	if err := cache.Put("a.txt", "first"); err != nil {
+		t.Errorf("Could not write a.txt: %v", err)
+		failed = true
+	}
+	// the datastore has the write before Put returns
+	if file, err := data.Get("a.txt"); err != nil || file != "first" {
+		t.Errorf("Expected first in the datastore, got %v: %v", file, err)
+		failed = true
+	}
+	// and the cache serves it without going back to the datastore
+	if file, err := cache.Fetch("a.txt", id); err != nil || file != "first" {
+		t.Errorf("Expected first from the cache, got %v: %v", file, err)
+		failed = true
+	}
+	if stats := cache.Report(); stats.Hits != 1 || stats.Misses != 0 {
+		hits, misses := stats.Hits, stats.Misses
+		t.Errorf("Expected 1 hit and 0 misses, got %d hits and %d misses.", hits, misses)
+		failed = true
+	}
+
+	// read-only datastores refuse writes
+	load := func(filename string) (string, error) {
+		return filename, nil
+	}
+	cache = MakeCache(id, Params[string]{MaxBytes: config.CACHE_BYTES}, datastore.MakeLoaderStore(load, []string{}))
+	if err := cache.Put("a.txt", "first"); !errors.Is(err, datastore.ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly, got %v", err)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	fmt.Printf("TestWriteBack ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	data.Make("b.txt", "b.txt")
+	data.Make("c.txt", "c.txt")
+
+	id := 1
+	params := Params[string]{MaxBytes: config.CACHE_BYTES, MaxEntries: 2, WriteMode: config.WriteBack}
+	cache := MakeCache(id, params, data)
+
+	stored := func(filename string) string {
+		file, _ := cache.data.Get(filename)
+		return file
+	}
+
+	cache.Put("a.txt", "dirty")
+	if stored("a.txt") != "" {
+		t.Errorf("Expected a.txt to stay out of the datastore until flushed")
+		failed = true
+	}
+	if file, _ := cache.Fetch("a.txt", id); file != "dirty" {
+		t.Errorf("Expected to read the write back, got %v", file)
+		failed = true
+	}
+
+	// evicting a.txt flushes it
+	cache.Fetch("b.txt", id)
+	cache.Fetch("c.txt", id)
+	if stored("a.txt") != "dirty" {
+		t.Errorf("Expected a.txt to be flushed on eviction, got %v", stored("a.txt"))
+		failed = true
+	}
+
+	cache.Put("b.txt", "flushed")
+	if err := cache.Flush(); err != nil || stored("b.txt") != "flushed" {
+		t.Errorf("Expected b.txt to be flushed, got %v: %v", stored("b.txt"), err)
+		failed = true
+	}
+
+	cache.Put("c.txt", "closed")
+	cache.Close()
+	if stored("c.txt") != "closed" {
+		t.Errorf("Expected c.txt to be flushed on close, got %v", stored("c.txt"))
+		failed = true
+	}
+
+	// and periodically
+	params.FlushInterval = 10 * time.Millisecond
+	cache = MakeCache(id, params, data)
+	defer cache.Close()
+	cache.Put("d.txt", "periodic")
+	time.Sleep(50 * time.Millisecond)
+	if stored("d.txt") != "periodic" {
+		t.Errorf("Expected d.txt to be flushed periodically, got %v", stored("d.txt"))
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestSharedBackend(t *testing.T) {
+	fmt.Printf("TestSharedBackend ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	params := Params[string]{MaxBytes: config.CACHE_BYTES, MaxEntries: config.CACHE_SIZE}
+
+	caches := make([]*Cache[string], 50)
+	for i := range caches {
+		caches[i] = MakeCache(i, params, data)
+		if caches[i].data != datastore.Backend[string](data) {
+			t.Errorf("Cache %d does not share the datastore", i)
+			failed = true
+		}
+	}
+
+	// files made after the caches are visible to all of them
+	for j := 0; j < 3; j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+		data.Make(filename, filename)
+	}
+
+	// calls are still counted per cache
+	for j := 0; j < 3; j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+		if file, err := caches[0].Fetch(filename, 0); err != nil || file != filename {
+			t.Errorf("Cache 0 could not open %s: %v", filename, err)
+			failed = true
+		}
+		if j < 2 {
+			caches[1].Fetch(filename, 0)
+		}
+	}
+
+	calls0 := caches[0].Report().Calls
+	calls1 := caches[1].Report().Calls
+	if calls0 != 3 || calls1 != 2 || data.CountCalls() != 5 {
+		t.Errorf("Expected 3 + 2 = 5 calls, got %d + %d = %d", calls0, calls1, data.CountCalls())
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	fmt.Printf("TestEvictionPolicy ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	for _, filename := range []string{"a", "b", "c", "d"} {
+		data.Make(filename, filename)
+	}
+
+	// a is used most often but least recently when d pushes something out
+	expected := map[config.EvictionType]int64{config.EvictLRU: 2, config.EvictLFU: 3}
+	for eType, hits := range expected {
+		params := Params[string]{Eviction: eType, MaxBytes: config.CACHE_BYTES, MaxEntries: 3}
+		cache := MakeCache(0, params, data)
+		for _, filename := range []string{"a", "a", "a", "b", "c", "d", "a", "b"} {
+			cache.Fetch(filename, 0)
+		}
+		if h := cache.Report().Hits; h != hits {
+			t.Errorf("Expected %d hits with eviction type %d, got %d", hits, eType, h)
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+// predicts that next follows after, and remembers what it saw
+type fixedPrefetcher struct {
+	mu			sync.Mutex
+	after		string
+	next		[]string
+	observed	[]string
+}
+
+func (p *fixedPrefetcher) Observe(filename string, clientID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.observed = append(p.observed, filename)
+}
+
+func (p *fixedPrefetcher) Predict(filename string, n int) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if filename != p.after {
+		return nil, nil
+	}
+	return p.next, nil
+}
+
+func (p *fixedPrefetcher) predictNext(next ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next = next
+}
+
+func TestPrefetcher(t *testing.T) {
+	fmt.Printf("TestPrefetcher ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	for _, filename := range []string{"a", "b"} {
+		data.Make(filename, filename)
+	}
+
+	// nothing is prefetched without a prefetcher, whatever the eviction policy
+	for _, eType := range []config.EvictionType{config.EvictLRU, config.EvictARC} {
+		cache := MakeCache(0, Params[string]{Prefetch: config.NoPrefetch, Eviction: eType, MaxBytes: config.CACHE_BYTES}, data)
+		cache.Fetch("a", 0)
+		if err := cache.BatchPrefetch("a"); err != nil {
+			t.Errorf("Expected no error prefetching nothing, got %v", err)
+			failed = true
+		}
+		if files, err := cache.Predict("a", 1); err != nil || len(files) != 0 {
+			t.Errorf("Expected no predictions, got %v, %v", files, err)
+			failed = true
+		}
+		if calls := cache.Report().Calls; calls != 1 {
+			t.Errorf("Expected 1 call to the datastore, got %d", calls)
+			failed = true
+		}
+	}
+
+	// a custom prefetcher sees every access and chooses what to prefetch
+	prefetcher := &fixedPrefetcher{after: "a", next: []string{"b"}}
+	cache := MakeCache(0, Params[string]{Prefetcher: prefetcher, Eviction: config.EvictLFU, MaxBytes: config.CACHE_BYTES}, data)
+	cache.Fetch("a", 0)
+	if err := cache.BatchPrefetch("a"); err != nil {
+		t.Errorf("Could not prefetch: %v", err)
+		failed = true
+	}
+	cache.Fetch("b", 0)
+	if hits := cache.Report().Hits; hits != 1 {
+		t.Errorf("Expected the prefetched file to hit, got %d hits", hits)
+		failed = true
+	}
+	if len(prefetcher.observed) != 2 || prefetcher.observed[1] != "b" {
+		t.Errorf("Expected the prefetcher to observe [a b], got %v", prefetcher.observed)
+		failed = true
+	}
+	if _, err := cache.LocalChain().Predict("a", 1); !errors.Is(err, markov.ErrUnknownFile) {
+		t.Errorf("Expected no local transitions without a markov prefetcher")
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestPrefetchProbation(t *testing.T) {
+	fmt.Printf("TestPrefetchProbation ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	hot := []string{"h1", "h2", "h3", "h4", "h5", "h6"}
+	for _, filename := range append(hot, "p1", "p2", "p3", "p4", "p5", "p6") {
+		data.Make(filename, filename)
+	}
+
+	// prefetches get a quarter of 8 entries
+	// the cache's own prefetches (after every PREFETCH_SIZE fetches) never predict anything
+	prefetcher := &fixedPrefetcher{after: "h6"}
+	cache := MakeCache(0, Params[string]{Prefetcher: prefetcher, MaxBytes: config.CACHE_BYTES, MaxEntries: 8, PrefetchShare: 0.25}, data)
+	for _, filename := range hot {
+		cache.Fetch(filename, 0)
+	}
+
+	// only 2 of the batch fit, and they don't evict each other or the hot files
+	prefetcher.predictNext("p1", "p2", "p3", "p4")
+	cache.BatchPrefetch("h6")
+	for _, filename := range hot {
+		cache.Fetch(filename, 0)
+	}
+	if hits := cache.Report().Hits; hits != 6 {
+		t.Errorf("Expected the prefetched batch to leave 6 hot files cached, got %d hits", hits)
+		failed = true
+	}
+	if stats := cache.Report(); stats.Prefetched != 2 || stats.PrefetchHits != 0 || stats.EvictedUnused != 0 {
+		prefetched, used, unused := stats.Prefetched, stats.PrefetchHits, stats.EvictedUnused
+		t.Errorf("Expected 2 prefetched, 0 used and 0 unused, got %d, %d and %d", prefetched, used, unused)
+		failed = true
+	}
+
+	// a request promotes p1 out of probation
+	cache.Fetch("p1", 0)
+	if hits := cache.Report().Hits; hits != 7 {
+		t.Errorf("Expected p1 to hit")
+		failed = true
+	}
+
+	// p5 takes p1's place in probation, p6 pushes out p2 which was never used
+	prefetcher.predictNext("p5")
+	cache.BatchPrefetch("h6")
+	prefetcher.predictNext("p6")
+	cache.BatchPrefetch("h6")
+	if stats := cache.Report(); stats.Prefetched != 4 || stats.PrefetchHits != 1 || stats.EvictedUnused != 1 {
+		prefetched, used, unused := stats.Prefetched, stats.PrefetchHits, stats.EvictedUnused
+		t.Errorf("Expected 4 prefetched, 1 used and 1 unused, got %d, %d and %d", prefetched, used, unused)
+		failed = true
+	}
+	cache.Fetch("p1", 0)
+	if hits := cache.Report().Hits; hits != 8 {
+		t.Errorf("Expected the promoted p1 to stay cached")
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestStats(t *testing.T) {
+	fmt.Printf("TestStats ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	for _, filename := range []string{"a", "b", "c"} {
+		data.Make(filename, filename)
+	}
+
+	prefetcher := &fixedPrefetcher{after: "a", next: []string{"b", "c"}}
+	cache := MakeCache(0, Params[string]{Prefetcher: prefetcher, MaxBytes: config.CACHE_BYTES}, data)
+	cache.Fetch("a", 0)
+	cache.BatchPrefetch("a")
+	cache.Fetch("b", 0)
+	cache.Fetch("b", 0)
+	cache.Fetch("a", 0)
+	cache.Invalidate("c")
+
+	stats := cache.Report()
+	if stats.Hits != 3 || stats.DemandHits != 2 || stats.PrefetchHits != 1 || stats.Misses != 1 {
+		t.Errorf("Expected 2 demand hits, 1 prefetch hit and 1 miss, got %+v", stats)
+		failed = true
+	}
+	if stats.PrefetchesIssued != 2 || stats.Prefetched != 2 || stats.EvictedUnused != 1 || stats.PrefetchBatches != 1 {
+		t.Errorf("Expected 1 batch of 2 prefetches with 1 unused, got %+v", stats)
+		failed = true
+	}
+	if stats.Calls != 2 || stats.Bytes != 2 || stats.Invalidations != 1 {
+		t.Errorf("Expected 2 calls, 2 bytes and 1 invalidation, got %+v", stats)
+		failed = true
+	}
+	if stats.MeanPrefetchLatency() < config.DATA_FETCH_TIME {
+		t.Errorf("Expected prefetches to take at least %v, got %v", config.DATA_FETCH_TIME, stats.MeanPrefetchLatency())
+		failed = true
+	}
+	if stats.HitRatio() != 0.75 || stats.DemandHitRatio() != 0.5 || stats.PrefetchHitRatio() != 0.25 {
+		t.Errorf("Expected hit ratios 0.75 = 0.5 + 0.25, got %v = %v + %v", stats.HitRatio(), stats.DemandHitRatio(), stats.PrefetchHitRatio())
+		failed = true
+	}
+	if stats.PrefetchAccuracy() != 0.5 || stats.PrefetchWaste() != 0.5 {
+		t.Errorf("Expected prefetch accuracy and waste of 0.5, got %v and %v", stats.PrefetchAccuracy(), stats.PrefetchWaste())
+		failed = true
+	}
+
+	// ratios of sums, not sums of ratios
+	total := stats.Add(Stats{Misses: 4})
+	if total.Hits != 3 || total.Misses != 5 || total.HitRatio() != 0.375 {
+		t.Errorf("Expected 3 hits and 5 misses, got %+v", total)
+		failed = true
+	}
+	if (Stats{}).HitRatio() != 0 || (Stats{}).MeanPrefetchLatency() != 0 {
+		t.Errorf("Expected empty stats to have zero ratios")
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestPrefetchThreshold(t *testing.T) {
+	fmt.Printf("TestPrefetchThreshold ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	for _, filename := range []string{"a", "b", "c"} {
+		data.Make(filename, filename)
+	}
+
+	// b follows a three times as often as c
+	expected := map[float64]int64{0.5: 1, 0.2: 2}
+	for threshold, issued := range expected {
+		chain := markov.MakeMarkovChain()
+		for _, filename := range []string{"a", "b", "a", "b", "a", "b", "a", "c"} {
+			chain.RecordTransition(filename, 0)
+		}
+		cache := MakeCache(0, Params[string]{Prefetcher: chain, PrefetchThreshold: threshold, MaxBytes: config.CACHE_BYTES}, data)
+		if err := cache.BatchPrefetch("a"); err != nil {
+			t.Errorf("Could not prefetch: %v", err)
+			failed = true
+		}
+		if stats := cache.Report(); stats.PrefetchesIssued != issued {
+			t.Errorf("Expected %d prefetches above %v, got %d", issued, threshold, stats.PrefetchesIssued)
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestClientPrefetch(t *testing.T) {
+	fmt.Printf("TestClientPrefetch ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	for _, filename := range []string{"a", "b", "c"} {
+		data.Make(filename, filename)
+	}
+
+	// client 1 goes from a to b, client 2 three times as often from a to c
+	chain := markov.MakeMarkovChainWithOptions(markov.Options{ClientWeight: 0.5})
+	for i := 0; i < 3; i++ {
+		chain.RecordTransition("a", 1)
+		chain.RecordTransition("b", 1)
+	}
+	for i := 0; i < 9; i++ {
+		chain.RecordTransition("a", 2)
+		chain.RecordTransition("c", 2)
+	}
+
+	expected := map[int]string{1: "b", 2: "c"}
+	for clientID, next := range expected {
+		cache := MakeCache(0, Params[string]{Prefetcher: chain, PrefetchThreshold: 0.5, MaxBytes: config.CACHE_BYTES}, data)
+		if err := cache.BatchPrefetchClient("a", clientID); err != nil {
+			t.Errorf("Could not prefetch: %v", err)
+			failed = true
+		}
+		cache.Fetch(next, clientID)
+		if stats := cache.Report(); stats.PrefetchesIssued != 1 || stats.PrefetchHits != 1 {
+			t.Errorf("Expected client %d to prefetch only %v, got %+v", clientID, next, stats)
+			failed = true
+		}
+	}
+
+	// without a client, the global chain decides
+	cache := MakeCache(0, Params[string]{Prefetcher: chain, PrefetchThreshold: 0.5, MaxBytes: config.CACHE_BYTES}, data)
+	cache.BatchPrefetch("a")
+	cache.Fetch("c", 1)
+	if stats := cache.Report(); stats.PrefetchesIssued != 1 || stats.PrefetchHits != 1 {
+		t.Errorf("Expected only c to be prefetched, got %+v", stats)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestWarmStart(t *testing.T) {
+	fmt.Printf("TestWarmStart ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	files := []string{"a", "b", "c", "d"}
+	for _, filename := range files {
+		data.Make(filename, filename)
+	}
+
+	// a cache learns a -> b -> c -> d, then goes down
+	cache := MakeCache(0, Params[string]{Prefetch: config.MarkovPrefetch, MaxBytes: config.CACHE_BYTES}, data)
+	for i := 0; i < 3; i++ {
+		for _, filename := range files {
+			cache.Fetch(filename, 0)
+		}
+	}
+	expected, _ := cache.Predict("a", 3)
+	path := filepath.Join(t.TempDir(), "chain.json")
+	if err := cache.SaveSnapshot(path); err != nil {
+		t.Errorf("Could not save snapshot: %v", err)
+		failed = true
+	}
+	cache.Close()
+
+	// and comes back knowing what it learned, locally too so syncing shares it
+	restarted := MakeCache(0, Params[string]{Prefetch: config.MarkovPrefetch, Snapshot: path, MaxBytes: config.CACHE_BYTES}, data)
+	if predict, _ := restarted.Predict("a", 3); len(expected) != 3 || strings.Join(predict, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v after a warm start, got %v", expected, predict)
+		failed = true
+	}
+	if predict, _ := restarted.LocalChain().Predict("a", 3); strings.Join(predict, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v from the local chain after a warm start, got %v", expected, predict)
+		failed = true
+	}
+	// but what it loaded isn't a change to ship
+	if changes := restarted.LocalChanges(); changes.Stats().Edges != 0 {
+		t.Errorf("Expected no local changes after a warm start, got %+v", changes.Stats())
+		failed = true
+	}
+	restarted.Fetch("d", 0)
+	restarted.Fetch("a", 0)
+	// the client's first access, and d -> a
+	if changes := restarted.LocalChanges(); changes.Stats().Edges != 2 {
+		t.Errorf("Expected only the new transitions to ship, got %+v", changes.Stats())
+		failed = true
+	}
+
+	// without a snapshot it starts cold
+	cold := MakeCache(0, Params[string]{Prefetch: config.MarkovPrefetch, Snapshot: path + ".missing", MaxBytes: config.CACHE_BYTES}, data)
+	if _, err := cold.Predict("a", 3); !errors.Is(err, markov.ErrUnknownFile) {
+		t.Errorf("Expected a cold start without a snapshot, got %v", err)
+		failed = true
+	}
+	if err := cold.LoadSnapshot(path + ".missing"); err == nil {
+		t.Errorf("Expected an error loading a missing snapshot")
+		failed = true
+	}
+	if errs := cold.Report().SnapshotErrors; errs != 1 {
+		t.Errorf("Expected the failed warm start to be counted, got %d", errs)
+		failed = true
+	}
+	corrupt := filepath.Join(t.TempDir(), "corrupt.json")
+	os.WriteFile(corrupt, []byte(`{"version": 99}`), 0644)
+	bad := MakeCache(0, Params[string]{Prefetch: config.MarkovPrefetch, Snapshot: corrupt, MaxBytes: config.CACHE_BYTES}, data)
+	if errs := bad.Report().SnapshotErrors; errs != 1 {
+		t.Errorf("Expected a snapshot of an unknown version to be counted, got %d", errs)
+		failed = true
+	}
+	if errs := restarted.Report().SnapshotErrors; errs != 0 {
+		t.Errorf("Expected no snapshot errors after a warm start, got %d", errs)
+		failed = true
+	}
+
+	none := MakeCache(0, Params[string]{Prefetch: config.NoPrefetch, MaxBytes: config.CACHE_BYTES}, data)
+	if err := none.LoadSnapshot(path); !errors.Is(err, ErrNoChain) {
+		t.Errorf("Expected ErrNoChain, got %v", err)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+// predicts nothing, but each prediction blocks until released, and remembers what it was asked
+type blockingPrefetcher struct {
+	mu			sync.Mutex
+	asked		[]string
+	started		chan string
+	release		chan struct{}
+}
+
+func makeBlockingPrefetcher() *blockingPrefetcher {
+	return &blockingPrefetcher{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (p *blockingPrefetcher) Observe(filename string, clientID int) {}
+
+func (p *blockingPrefetcher) Predict(filename string, n int) ([]string, error) {
+	p.mu.Lock()
+	p.asked = append(p.asked, filename)
+	p.mu.Unlock()
+	p.started <- filename
+	<-p.release
+	return nil, nil
+}
+
+func (p *blockingPrefetcher) waitAsked(n int) string {
+	for i := 0; i < 1000; i++ {
+		p.mu.Lock()
+		asked := strings.Join(p.asked, " ")
+		done := len(p.asked) >= n
+		p.mu.Unlock()
+		if done {
+			return asked
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return strings.Join(p.asked, " ")
+}
+
+func TestPrefetchQueue(t *testing.T) {
+	fmt.Printf("TestPrefetchQueue ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+
+	// one worker busy with a, b c d queued for a queue of 2
+	expected := map[config.DropPolicy]string{config.DropOldest: "a c d", config.DropNewest: "a b c"}
+	for policy, asked := range expected {
+		prefetcher := makeBlockingPrefetcher()
+		cache := MakeCache(0, Params[string]{Prefetcher: prefetcher, PrefetchWorkers: 1, PrefetchQueue: 2, PrefetchDrop: policy, MaxBytes: config.CACHE_BYTES}, data)
+		cache.prefetches.submit(prefetchJob{"a", 0})
+		<-prefetcher.started
+		for _, filename := range []string{"b", "c", "c", "d"} {
+			cache.prefetches.submit(prefetchJob{filename, 0})
+		}
+		if stats := cache.Report(); stats.PrefetchQueueDepth != 2 || stats.PrefetchDropped != 1 {
+			t.Errorf("Expected 2 queued and 1 dropped prefetch, got %+v", stats)
+			failed = true
+		}
+		close(prefetcher.release)
+		if received := prefetcher.waitAsked(3); received != asked {
+			t.Errorf("Expected prefetches for %v, got %v", asked, received)
+			failed = true
+		}
+		cache.Close()
+	}
+
+	// closing drops the queue and waits for the running prefetch
+	prefetcher := makeBlockingPrefetcher()
+	cache := MakeCache(0, Params[string]{Prefetcher: prefetcher, PrefetchWorkers: 1, MaxBytes: config.CACHE_BYTES}, data)
+	cache.prefetches.submit(prefetchJob{"a", 0})
+	<-prefetcher.started
+	cache.prefetches.submit(prefetchJob{"b", 0})
+	cache.prefetches.submit(prefetchJob{"c", 0})
+	closed := make(chan struct{})
+	go func() {
+		cache.Close()
+		close(closed)
+	}()
+	for i := 0; i < 1000 && cache.Report().PrefetchDropped != 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-closed:
+		t.Errorf("Expected Close to wait for the running prefetch")
+		failed = true
+	default:
+	}
+	close(prefetcher.release)
+	<-closed
+	if asked := prefetcher.waitAsked(1); asked != "a" {
+		t.Errorf("Expected only a to be prefetched, got %v", asked)
+		failed = true
+	}
+	if stats := cache.Report(); stats.PrefetchDropped != 2 || stats.PrefetchQueueDepth != 0 {
+		t.Errorf("Expected the 2 queued prefetches to be dropped, got %+v", stats)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestNoPrefetchWorkers(t *testing.T) {
+	fmt.Printf("TestNoPrefetchWorkers ...\n")
+	failed := false
+
+	// caches that never prefetch start no goroutines, so they don't leak unclosed
+	data := datastore.MakeDataStore[string]()
+	data.Make("a", "a")
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		cache := MakeCache(i, Params[string]{Prefetch: config.NoPrefetch, MaxBytes: config.CACHE_BYTES}, data)
+		for j := 0; j < config.PREFETCH_SIZE; j++ {
+			cache.Fetch("a", 0)
+		}
+		if stats := cache.Report(); stats.PrefetchQueueDepth != 0 || stats.PrefetchDropped != 0 {
+			t.Errorf("Expected no prefetches queued or dropped, got %+v", stats)
+			failed = true
+		}
+	}
+	if after := runtime.NumGoroutine(); after >= before + 10 {
+		t.Errorf("Expected no prefetch workers, went from %d to %d goroutines", before, after)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestFetchClosed(t *testing.T) {
+	fmt.Printf("TestFetchClosed ...\n")
+	failed := false
+
+	data := datastore.MakeDataStore[string]()
+	data.Make("a", "a")
+	data.Make("b", "b")
+	cache := MakeCache(0, Params[string]{Prefetch: config.MarkovPrefetch, MaxBytes: config.CACHE_BYTES}, data)
+	cache.Fetch("a", 0)
+	cache.LocalChanges()
+	cache.Close()
+
+	// fetches a closed cache refuses aren't recorded, so they never ship
+	if _, err := cache.Fetch("b", 0); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+		failed = true
+	}
+	if changes := cache.LocalChanges(); changes.Stats().Edges != 0 {
+		t.Errorf("Expected no changes from a closed cache, got %+v", changes.Stats())
+		failed = true
+	}
+	if predict, _ := cache.Predict("a", 1); len(predict) != 0 {
+		t.Errorf("Expected nothing to follow a, got %v", predict)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestPrefetchDedup(t *testing.T) {
+	fmt.Printf("TestPrefetchDedup ...\n")
+	failed := false
+
+	// loading b blocks until released
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	loading := make(chan struct{}, 1)
+	release := make(chan struct{})
+	releaseC := make(chan struct{})
+	data := datastore.MakeLoaderStore(func(filename string) (string, error) {
+		mu.Lock()
+		loads[filename]++
+		mu.Unlock()
+		if filename == "b" {
+			loading <- struct{}{}
+			<-release
+		} else if filename == "c" {
+			loading <- struct{}{}
+			<-releaseC
+		}
+		return filename, nil
+	}, []string{"a", "b", "c"})
+
+	prefetcher := &fixedPrefetcher{after: "a", next: []string{"b", "b"}}
+	cache := MakeCache(0, Params[string]{Prefetcher: prefetcher, MaxBytes: config.CACHE_BYTES}, data)
+	defer cache.Close()
+	cache.Fetch("a", 0)
+	go cache.BatchPrefetch("a")
+	<-loading
+
+	// other requests go on while b is being prefetched
+	if file, err := cache.Fetch("a", 0); err != nil || file != "a" {
+		t.Errorf("Expected a while b is prefetched, got %v, %v", file, err)
+		failed = true
+	}
+	// a second prefetch of b is left to the first
+	if err := cache.BatchPrefetch("a"); err != nil {
+		t.Errorf("Could not prefetch: %v", err)
+		failed = true
+	}
+	// and so is a request for b
+	fetched := make(chan string)
+	go func() {
+		file, _ := cache.Fetch("b", 0)
+		fetched <- file
+	}()
+	for i := 0; i < 1000 && cache.Report().PrefetchWaits != 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if file := <-fetched; file != "b" {
+		t.Errorf("Expected b, got %v", file)
+		failed = true
+	}
+
+	mu.Lock()
+	if loads["b"] != 1 {
+		t.Errorf("Expected b to be loaded once, got %d", loads["b"])
+		failed = true
+	}
+	mu.Unlock()
+	if stats := cache.Report(); stats.PrefetchWaits != 1 || stats.PrefetchHits != 1 || stats.Calls != 2 {
+		t.Errorf("Expected one call for a and one for b, served as a prefetch hit, got %+v", stats)
+		failed = true
+	}
+
+	// batches added by hand are prefetches like any other
+	go cache.AddBatchToCache([]string{"c"})
+	<-loading
+	go func() {
+		file, _ := cache.Fetch("c", 0)
+		fetched <- file
+	}()
+	for i := 0; i < 1000 && cache.Report().PrefetchWaits != 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	close(releaseC)
+	if file := <-fetched; file != "c" {
+		t.Errorf("Expected c, got %v", file)
+		failed = true
+	}
+	mu.Lock()
+	if loads["c"] != 1 {
+		t.Errorf("Expected c to be loaded once, got %d", loads["c"])
+		failed = true
+	}
+	mu.Unlock()
+	if waits := cache.Report().PrefetchWaits; waits != 2 {
+		t.Errorf("Expected the request for c to wait for the batch, got %d waits", waits)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestCoalescedMisses(t *testing.T) {
+	fmt.Printf("TestCoalescedMisses ...\n")
+	failed := false
+
+	// loading slow blocks until released
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	release := make(chan struct{})
+	data := datastore.MakeLoaderStore(func(filename string) (string, error) {
+		mu.Lock()
+		loads[filename]++
+		mu.Unlock()
+		if filename == "slow" {
+			<-release
+		}
+		return filename, nil
+	}, []string{"slow", "fast", "other"})
+
+	cache := MakeCache(0, Params[string]{MaxBytes: config.CACHE_BYTES}, data)
+	defer cache.Close()
+	cache.Fetch("fast", 0)
+
+	// n misses on slow at once
+	n := 8
+	fetched := make(chan string, n)
+	for i := 0; i < n; i++ {
+		go func(id int) {
+			file, err := cache.Fetch("slow", id)
+			if err != nil {
+				file = err.Error()
+			}
+			fetched <- file
+		}(i)
+	}
+	for i := 0; i < 1000 && cache.Report().Coalesced != int64(n - 1); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// hits and misses on other files go on while slow is being fetched
+	done := make(chan struct{})
+	go func() {
+		cache.Fetch("fast", 0)
+		cache.Fetch("other", 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Expected fast and other to be served while slow is fetched")
+		failed = true
+	}
+
+	close(release)
+	for i := 0; i < n; i++ {
+		if file := <-fetched; file != "slow" {
+			t.Errorf("Expected slow, got %v", file)
+			failed = true
+		}
+	}
+
+	mu.Lock()
+	if loads["slow"] != 1 {
+		t.Errorf("Expected slow to be loaded once, got %d", loads["slow"])
+		failed = true
+	}
+	mu.Unlock()
+	stats := cache.Report()
+	if stats.Coalesced != int64(n - 1) || stats.Misses != int64(n + 2) || stats.Hits != 1 || stats.Calls != 3 {
+		t.Errorf("Expected %d coalesced misses and one call for each file, got %+v", n - 1, stats)
+		failed = true
+	}
+	if file, err := cache.Fetch("slow", 0); err != nil || file != "slow" || cache.Report().Hits != 2 {
+		t.Errorf("Expected slow to be cached once fetched, got %v, %v", file, err)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
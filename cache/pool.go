@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/smart-cache/smart-cache-go/config"
+)
+
+/********************************
+prefetchPool runs prefetches on a fixed number of workers, so a burst of
+accesses can't start an unbounded number of goroutines
+makePrefetchPool(workers int, capacity int, drop config.DropPolicy, run func(prefetchJob)) *prefetchPool
+	Starts workers goroutines calling run on queued prefetches, oldest first
+p.submit(job prefetchJob)
+	Queue a prefetch, unless the same one is already queued
+	A full queue drops its oldest prefetch (DropOldest) or this one (DropNewest)
+p.close()
+	Drop every queued prefetch and wait for the running ones to return
+p.stats() (int64, int64)
+	Number of queued prefetches, and of prefetches dropped so far
+*********************************/
+
+// a prefetch of what clientID is likely to access after filename
+type prefetchJob struct {
+	filename	string
+	clientID	int
+}
+
+type prefetchPool struct {
+	mu			sync.Mutex
+	ready		*sync.Cond						// signalled when a prefetch is queued or the pool closes
+	queue		[]prefetchJob					// oldest first
+	capacity	int
+	drop		config.DropPolicy
+	run			func(prefetchJob)
+	dropped		int64							// prefetches dropped because the queue was full or closed
+	closed		bool
+	workers		sync.WaitGroup
+}
+
+func makePrefetchPool(workers int, capacity int, drop config.DropPolicy, run func(prefetchJob)) *prefetchPool {
+	p := &prefetchPool{
+		queue: make([]prefetchJob, 0, capacity),
+		capacity: capacity,
+		drop: drop,
+		run: run,
+	}
+	p.ready = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+func (p *prefetchPool) submit(job prefetchJob) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		p.dropped++
+		return
+	}
+	for _, queued := range p.queue {
+		if queued == job {
+			return
+		}
+	}
+	if len(p.queue) >= p.capacity {
+		p.dropped++
+		if p.drop == config.DropNewest {
+			return
+		}
+		// shift rather than reslice, so the queue never outgrows its capacity
+		p.queue = append(p.queue[:0], p.queue[1:]...)
+	}
+	p.queue = append(p.queue, job)
+	p.ready.Signal()
+}
+
+func (p *prefetchPool) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		p.dropped += int64(len(p.queue))
+		p.queue = p.queue[:0]
+		p.ready.Broadcast()
+	}
+	p.mu.Unlock()
+	p.workers.Wait()
+}
+
+func (p *prefetchPool) stats() (int64, int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int64(len(p.queue)), p.dropped
+}
+
+func (p *prefetchPool) work() {
+	defer p.workers.Done()
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.ready.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		job := p.queue[0]
+		p.queue = append(p.queue[:0], p.queue[1:]...)
+		p.mu.Unlock()
+
+		p.run(job)
+	}
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"github.com/smart-cache/smart-cache-go/config"
+	"github.com/smart-cache/smart-cache-go/markov"
+)
+
+/********************************
+Prefetcher decides which files a cache fetches before they are requested,
+independently of which files it evicts.
+p.Observe(filename string, clientID int)
+	A client accessed filename
+p.Predict(filename string, n int) ([]string, error)
+	Up to n files likely to be accessed after filename, in order of likelihood
+
+ThresholdPrefetcher is a Prefetcher that also knows how likely its predictions are
+p.PredictProbable(filename string, n int, threshold float64) ([]markov.Prediction, error)
+	Like Predict, but only files at least threshold likely, with their probabilities
+
+ClientPrefetcher is a Prefetcher that predicts differently for every client
+p.PredictClient(filename string, clientID int, n int, threshold float64) ([]markov.Prediction, error)
+	Up to n files likely to be accessed after clientID accesses filename, at least
+	threshold likely (any if 0), with their probabilities
+
+MakePrefetcher(t config.PrefetchType, opts markov.Options) Prefetcher
+	NoPrefetch never predicts anything, MarkovPrefetch uses a markov.MarkovChain
+	that forgets old transitions as opts says, and mixes in a chain per client if
+	opts.ClientWeight > 0
+*********************************/
+
+type Prefetcher interface {
+	Observe(filename string, clientID int)
+	Predict(filename string, n int) ([]string, error)
+}
+
+type ThresholdPrefetcher interface {
+	Prefetcher
+	PredictProbable(filename string, n int, threshold float64) ([]markov.Prediction, error)
+}
+
+type ClientPrefetcher interface {
+	Prefetcher
+	PredictClient(filename string, clientID int, n int, threshold float64) ([]markov.Prediction, error)
+}
+
+// prefetches nothing, so files are only fetched on demand
+type NoPrefetcher struct{}
+
+func (NoPrefetcher) Observe(filename string, clientID int) {}
+
+func (NoPrefetcher) Predict(filename string, n int) ([]string, error) {
+	return nil, nil
+}
+
+func MakePrefetcher(t config.PrefetchType, opts markov.Options) Prefetcher {
+	if t == config.MarkovPrefetch {
+		return markov.MakeMarkovChainWithOptions(opts)
+	}
+	return NoPrefetcher{}
+}
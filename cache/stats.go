@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"time"
+)
+
+// counters kept by a cache, summed across caches with Add
+// every hit is either a demand hit or a prefetch hit, a prefetch hit being the
+// first request for a file that was prefetched rather than requested
+type Stats struct {
+	Hits				int64			// requests served from the cache
+	DemandHits			int64			// hits on files cached because they were requested before
+	PrefetchHits		int64			// hits on prefetched files, i.e. useful prefetches
+	Misses				int64			// requests that went to the datastore
+	Calls				int64			// calls to the datastore, for any reason
+	Bytes				int64			// bytes currently cached
+	PrefetchesIssued	int64			// files requested from the datastore by prefetching
+	Prefetched			int64			// prefetched files that were cached
+	EvictedUnused		int64			// prefetched files dropped before they were ever requested
+	PrefetchLatency		time.Duration	// total time spent waiting on the datastore for prefetches
+	PrefetchBatches		int64			// calls to the datastore for prefetches
+	PrefetchQueueDepth	int64			// prefetches waiting for a worker, filled in by Report
+	PrefetchDropped		int64			// prefetches dropped because the queue was full or the cache closed
+	PrefetchWaits		int64			// requests that waited for a prefetch of the same file instead of fetching it
+	Coalesced			int64			// misses that waited for another miss on the same file and shared its result
+	Expirations			int64			// files dropped because their TTL ran out
+	Invalidations		int64			// files dropped by Invalidate
+	SnapshotErrors		int64			// snapshots MakeCache couldn't load, so the cache started cold
+}
+
+// returns the sum of both stats
+func (s Stats) Add(other Stats) Stats {
+	return Stats{
+		Hits: s.Hits + other.Hits,
+		DemandHits: s.DemandHits + other.DemandHits,
+		PrefetchHits: s.PrefetchHits + other.PrefetchHits,
+		Misses: s.Misses + other.Misses,
+		Calls: s.Calls + other.Calls,
+		Bytes: s.Bytes + other.Bytes,
+		PrefetchesIssued: s.PrefetchesIssued + other.PrefetchesIssued,
+		Prefetched: s.Prefetched + other.Prefetched,
+		EvictedUnused: s.EvictedUnused + other.EvictedUnused,
+		PrefetchLatency: s.PrefetchLatency + other.PrefetchLatency,
+		PrefetchBatches: s.PrefetchBatches + other.PrefetchBatches,
+		PrefetchQueueDepth: s.PrefetchQueueDepth + other.PrefetchQueueDepth,
+		PrefetchDropped: s.PrefetchDropped + other.PrefetchDropped,
+		PrefetchWaits: s.PrefetchWaits + other.PrefetchWaits,
+		Coalesced: s.Coalesced + other.Coalesced,
+		Expirations: s.Expirations + other.Expirations,
+		Invalidations: s.Invalidations + other.Invalidations,
+		SnapshotErrors: s.SnapshotErrors + other.SnapshotErrors,
+	}
+}
+
+// share of requests served from the cache
+func (s Stats) HitRatio() float64 {
+	return ratio(s.Hits, s.Hits + s.Misses)
+}
+
+// share of requests served by files cached on an earlier request
+func (s Stats) DemandHitRatio() float64 {
+	return ratio(s.DemandHits, s.Hits + s.Misses)
+}
+
+// share of requests served by prefetched files
+func (s Stats) PrefetchHitRatio() float64 {
+	return ratio(s.PrefetchHits, s.Hits + s.Misses)
+}
+
+// share of prefetched files that were requested
+func (s Stats) PrefetchAccuracy() float64 {
+	return ratio(s.PrefetchHits, s.Prefetched)
+}
+
+// share of prefetched files dropped without being requested
+func (s Stats) PrefetchWaste() float64 {
+	return ratio(s.EvictedUnused, s.Prefetched)
+}
+
+// average time the datastore took to answer a prefetch
+func (s Stats) MeanPrefetchLatency() time.Duration {
+	if s.PrefetchBatches == 0 {
+		return 0
+	}
+	return s.PrefetchLatency / time.Duration(s.PrefetchBatches)
+}
+
+func ratio(n int64, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
@@ -0,0 +1,286 @@
+package cachemaster
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"github.com/smart-cache/smart-cache-go/datastore"
+	"github.com/smart-cache/smart-cache-go/markov"
+	"github.com/smart-cache/smart-cache-go/config"
+	"github.com/smart-cache/smart-cache-go/cache"
+)
+
+/************************************************
+Cache Master API
+Initialization:
+    m = MakeCacheMaster[V](
+            clientIds       []int
+            prefetch PrefetchType - prefetch policy, combined with any eviction policy
+            numCaches         int - number of cache machines to use
+            replication       int - replication factor
+            datastore     Backend - anything the caches can fetch data from
+        )
+    Initialize a cache master with client list, and replication factor (r)
+    For MarkovPrefetch caches with Sync_ms > 0, starts periodically syncing the caches
+    With a Snapshot, the caches and the aggregate they sync start from it
+m.GetCaches(file string, clientID int) []int
+    Ordering of the replicas of `file` that a client should try
+m.GetCache(cacheID int) *cache.Cache[V]
+    Get a cache by ID (nil if there is no such cache)
+m.Invalidate(file string) int
+    Drop `file` from every replica, returns how many replicas had it cached
+m.Put(file string, value V) error
+    Write `value` to `file` and to every replica of it, so every client reads it next
+    WriteThrough writes the datastore once and refreshes every replica
+    WriteBack writes every replica, which write the datastore when flushed
+    Every replica is written even if some fail, the error names each one that failed
+m.Flush() error
+    Flush every cache
+m.Report() cache.Stats
+    Sum of the stats of every cache, so ratios are over all requests to any cache
+m.Close() error
+    Stop syncing and close (flush) every cache. Safe to call more than once
+syncCaches
+    Every sync_ms, merges the changes to the transitions observed by each cache
+    since the last sync into the aggregate chain and pushes the aggregate back to
+    every cache
+*************************************************/
+
+type CacheMaster[V any] struct {
+	mu			sync.Mutex						// lock on master structure
+	clientIDs	[]int							// list of all client IDs (TODO: rm if unnecessary)
+	caches		map[int]*cache.Cache[V]			// map of cache ID -> cache
+	prefetch	config.PrefetchType				// prefetch policy of all caches
+	rFactor		int 							// replication factor
+	nCaches		int 							// number of caches
+	nFiles		int 							// number of pieces of data	(TODO: rm if unnecessary)
+	datastore	datastore.Backend[V]			// underlying datastore that all caches have access to (TODO: rm if redundant)
+	hash		*Hash							// underlying hash method for splitting data access across caches
+	sync_time	int 							// how often caches are synced
+	chain		*markov.MarkovChain				// sum of the transitions observed by every cache, as of the last sync
+	smu			sync.Mutex						// serializes syncs, so every change is merged once
+	writeMode	config.WriteMode				// write mode of all caches
+	wmu			sync.Mutex						// serializes writes so every replica agrees on the last one
+	done		chan struct{}					// closed to stop syncing
+	closed		bool							// whether Close has been called
+}
+
+type CacheParams[V any] struct {
+	NCaches 		int 						// number of caches
+	RFactor 		int							// replication factor
+	Prefetch		config.PrefetchType			// prefetch policy of each cache (NoPrefetch | MarkovPrefetch)
+	PrefetchShare	float64						// share of each cache for unused prefetched files, PREFETCH_SHARE if 0
+	Markov			markov.Options				// how MarkovPrefetch caches forget old transitions and weigh clients
+	Snapshot		string						// markov chain snapshot every cache and the aggregate warm-start from, if any
+	PrefetchThreshold	float64					// least probability of a prefetched file, 0 to prefetch every prediction
+	PrefetchWorkers	int							// goroutines prefetching for each cache, PREFETCH_WORKERS if 0
+	PrefetchQueue	int							// prefetches waiting for a worker in each cache, PREFETCH_QUEUE if 0
+	PrefetchDrop	config.DropPolicy			// which prefetch a full queue drops, DropOldest by default
+	Eviction		config.EvictionType			// eviction policy of each cache, LRU by default
+	CacheSize 		int64						// size of each cache in bytes (assumes homogeneity), CACHE_BYTES if 0
+	CacheEntries	int64						// maximum number of files in each cache, 0 for no limit
+	Datastore 		datastore.Backend[V]		// underlying datastore that all caches have access to (any Backend)
+	Sync_ms 		int							// how many milliseconds to wait in between cache syncs 
+	WriteMode		config.WriteMode			// WriteThrough | WriteBack
+	FlushInterval	time.Duration				// how often WriteBack caches flush, 0 to only flush on eviction
+}
+
+func MakeCacheMaster[V any](clientIDs []int, params CacheParams[V]) (* CacheMaster[V]) {
+	// k: number of caches
+	// r: replication factor for data desired
+	// this is trivial (can store everything) if cacheSize >= nr/k (where n is
+	// size of datastore)
+	cm := &CacheMaster[V]{
+		clientIDs: clientIDs,
+		nCaches: params.NCaches,
+		rFactor: params.RFactor,
+		datastore: params.Datastore,
+		nFiles: params.Datastore.Size(),
+		chain: aggregateChain(params.Markov),
+		sync_time: params.Sync_ms,
+		writeMode: params.WriteMode,
+		caches: make(map[int]*cache.Cache[V]),
+		done: make(chan struct{}),
+	}
+
+	if params.Prefetch == config.MarkovPrefetch && params.Snapshot != "" {
+		// caches don't ship what they load, so the aggregate counts the snapshot once
+		// caches count it in their stats if it can't be loaded
+		if snapshot, err := markov.LoadFile(params.Snapshot); err == nil {
+			cm.chain.Rebase(snapshot)
+		}
+	}
+
+	for i := 0; i < cm.nCaches; i++ {
+		// every cache shares the one datastore
+		cacheParams := cache.Params[V]{
+			Prefetch: params.Prefetch,
+			PrefetchShare: params.PrefetchShare,
+			Markov: params.Markov,
+			Snapshot: params.Snapshot,
+			PrefetchThreshold: params.PrefetchThreshold,
+			PrefetchWorkers: params.PrefetchWorkers,
+			PrefetchQueue: params.PrefetchQueue,
+			PrefetchDrop: params.PrefetchDrop,
+			Eviction: params.Eviction,
+			MaxBytes: params.CacheSize,
+			MaxEntries: params.CacheEntries,
+			WriteMode: params.WriteMode,
+			FlushInterval: params.FlushInterval,
+		}
+		c := cache.MakeCache(i, cacheParams, params.Datastore)
+		cm.caches[i] = c
+	}
+
+
+	cm.hash = MakeHash(cm.nCaches, cm.datastore.Keys(), cm.nFiles, cm.rFactor, cm.clientIDs)
+
+    if (params.Prefetch == config.MarkovPrefetch && params.Sync_ms > 0) {
+        go cm.syncCaches(params.Sync_ms)
+    }
+
+	return cm
+}
+
+// returns the order in which clientID should try the replicas of file
+func (cm *CacheMaster[V]) GetCaches(file string, clientID int) []int {
+	order := cm.hash.GetCaches(file, clientID)
+	// copy so callers can't reorder the hash's replicas
+	caches := make([]int, len(order))
+	copy(caches, order)
+	return caches
+}
+
+func (cm *CacheMaster[V]) GetCache(cacheID int) *cache.Cache[V] {
+	return cm.caches[cacheID]
+}
+
+// drops filename from every cache in its replica group
+func (cm *CacheMaster[V]) Invalidate(filename string) int {
+	cacheIDs := cm.hash.GetCachesForFile(filename)
+	if cacheIDs == nil {
+		// the hash doesn't know this file, so any cache may hold it
+		for i := 0; i < cm.nCaches; i++ {
+			cacheIDs = append(cacheIDs, i)
+		}
+	}
+
+	invalidated := 0
+	for _, cacheID := range cacheIDs {
+		if cm.caches[cacheID].Invalidate(filename) {
+			invalidated++
+		}
+	}
+	return invalidated
+}
+
+func (cm *CacheMaster[V]) Report() cache.Stats {
+	var stats cache.Stats
+	for i := 0; i < cm.nCaches; i++ {
+		stats = stats.Add(cm.caches[i].Report())
+	}
+	return stats
+}
+
+func (cm *CacheMaster[V]) Put(filename string, value V) error {
+	cm.wmu.Lock()
+	defer cm.wmu.Unlock()
+
+	// files created after the hash was made are placed now
+	cacheIDs := cm.hash.AddFile(filename)
+
+	if cm.writeMode == config.WriteThrough {
+		writer, ok := cm.datastore.(datastore.Writer[V])
+		if !ok {
+			return fmt.Errorf("failed to write %v: %w", filename, datastore.ErrReadOnly)
+		}
+		if err := writer.Put(filename, value); err != nil {
+			return err
+		}
+		return cm.eachReplica(filename, cacheIDs, func(c *cache.Cache[V]) error {
+			return c.Refresh(filename, value)
+		})
+	}
+
+	// every replica holds the write until it flushes, so none can serve an older copy
+	return cm.eachReplica(filename, cacheIDs, func(c *cache.Cache[V]) error {
+		return c.Put(filename, value)
+	})
+}
+
+// writes every replica, even after one fails, so one bad replica doesn't leave the
+// rest out of date; the error names every replica that failed
+func (cm *CacheMaster[V]) eachReplica(filename string, cacheIDs []int, write func(*cache.Cache[V]) error) error {
+	var errs []error
+	for _, cacheID := range cacheIDs {
+		if err := write(cm.caches[cacheID]); err != nil {
+			errs = append(errs, fmt.Errorf("replica %d failed to write %v: %w", cacheID, filename, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (cm *CacheMaster[V]) Flush() error {
+	var err error
+	for i := 0; i < cm.nCaches; i++ {
+		if ferr := cm.caches[i].Flush(); ferr != nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// stops the periodic syncing of caches and closes them
+func (cm *CacheMaster[V]) Close() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	var err error
+	if !cm.closed {
+		cm.closed = true
+		close(cm.done)
+		for _, c := range cm.caches {
+			if cerr := c.Close(); cerr != nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+func (cm *CacheMaster[V]) syncCaches(sync_ms int) {
+	ticker := time.NewTicker(time.Duration(sync_ms) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cm.done:
+			return
+		case <-ticker.C:
+			cm.syncOnce()
+		}
+	}
+}
+
+// the aggregate is bounded and forgets like the caches' chains, so it doesn't outgrow them
+// client chains are never synced, so it doesn't need them
+func aggregateChain(opts markov.Options) *markov.MarkovChain {
+	opts.ClientWeight = 0
+	return markov.MakeMarkovChainWithOptions(opts)
+}
+
+// merges the transitions seen by every cache and pushes the aggregate back out
+func (cm *CacheMaster[V]) syncOnce() {
+	cm.smu.Lock()
+	defer cm.smu.Unlock()
+
+	// caches only ship what changed since the last sync, including what they forgot,
+	// so the aggregate stays the sum of every cache's transitions
+	for i := 0; i < cm.nCaches; i++ {
+		cm.chain.Merge(cm.caches[i].LocalChanges())
+	}
+
+	for i := 0; i < cm.nCaches; i++ {
+		cm.caches[i].SyncChain(cm.chain)
+	}
+}
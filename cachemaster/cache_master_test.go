@@ -0,0 +1,468 @@
+package cachemaster
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"github.com/smart-cache/smart-cache-go/datastore"
+	"github.com/smart-cache/smart-cache-go/markov"
+	"github.com/smart-cache/smart-cache-go/config"
+)
+
+func MakeTestDatastore(n int) *datastore.DataStore[string] {
+	data := datastore.MakeDataStore[string]()
+	for j := 0; j < n; j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+		data.Make(filename, filename)
+	}
+	return data
+}
+
+func CheckPredictions(received []string, expected []string, t *testing.T) bool {
+	if len(received) != len(expected) {
+		t.Errorf("CheckPredictions FAILED. Received: %v instead of %v", received, expected)
+		return false
+	}
+	for index, file := range received {
+		if file != expected[index] {
+			t.Errorf("CheckPredictions FAILED. Received: file %v instead of file %v", file, expected[index])
+			return false
+		}
+	}
+	return true
+}
+
+func TestSyncImprovesPrediction(t *testing.T) {
+	fmt.Printf("TestSyncImprovesPrediction ...\n")
+	failed := false
+
+	data := MakeTestDatastore(4)
+	params := CacheParams[string]{
+		NCaches: 2,
+		RFactor: 1,
+		Prefetch: config.MarkovPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		CacheEntries: config.CACHE_SIZE,
+		Datastore: data,
+		Sync_ms: 0, // sync by hand
+	}
+	cm := MakeCacheMaster([]int{0, 1}, params)
+	defer cm.Close()
+
+	// only cache 0 sees the access pattern
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 4; j++ {
+			cm.caches[0].Fetch("fake_" + strconv.Itoa(j) + ".txt", 0)
+		}
+	}
+	// cache 1 has only seen the first file
+	cm.caches[1].Fetch("fake_0.txt", 1)
+
+	expected := []string{"fake_1.txt", "fake_2.txt", "fake_3.txt"}
+
+	if before, _ := cm.caches[1].Predict("fake_0.txt", 3); len(before) != 0 {
+		t.Errorf("Expected no predictions before sync, got %v", before)
+		failed = true
+	}
+
+	cm.syncOnce()
+
+	predictions, _ := cm.caches[1].Predict("fake_0.txt", 3)
+	if !CheckPredictions(predictions, expected, t) {
+		failed = true
+	}
+	// cache 0 must not lose what it learned
+	predictions, _ = cm.caches[0].Predict("fake_0.txt", 3)
+	if !CheckPredictions(predictions, expected, t) {
+		failed = true
+	}
+
+	// syncing again must not double count the same transitions
+	cm.syncOnce()
+	cm.syncOnce()
+	predictions, _ = cm.caches[1].Predict("fake_0.txt", 3)
+	if !CheckPredictions(predictions, expected, t) {
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestPeriodicSync(t *testing.T) {
+	fmt.Printf("TestPeriodicSync ...\n")
+	failed := false
+
+	data := MakeTestDatastore(4)
+	params := CacheParams[string]{
+		NCaches: 2,
+		RFactor: 1,
+		Prefetch: config.MarkovPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		CacheEntries: config.CACHE_SIZE,
+		Datastore: data,
+		Sync_ms: 10,
+	}
+	cm := MakeCacheMaster([]int{0, 1}, params)
+
+	cm.caches[1].Fetch("fake_0.txt", 1)
+	for j := 0; j < 4; j++ {
+		cm.caches[0].Fetch("fake_" + strconv.Itoa(j) + ".txt", 0)
+	}
+
+	// wait for a few sync periods
+	time.Sleep(50 * time.Millisecond)
+
+	predictions, _ := cm.caches[1].Predict("fake_0.txt", 3)
+	if !CheckPredictions(predictions, []string{"fake_1.txt", "fake_2.txt", "fake_3.txt"}, t) {
+		failed = true
+	}
+
+	// closing must stop syncing and be safe to repeat
+	cm.Close()
+	cm.Close()
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestInvalidateReplicas(t *testing.T) {
+	fmt.Printf("TestInvalidateReplicas ...\n")
+	failed := false
+
+	data := MakeTestDatastore(8)
+	params := CacheParams[string]{
+		NCaches: 4,
+		RFactor: 2,
+		Prefetch: config.NoPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		CacheEntries: config.CACHE_SIZE,
+		Datastore: data,
+	}
+	cm := MakeCacheMaster([]int{0, 1}, params)
+	defer cm.Close()
+
+	filename := "fake_0.txt"
+	// cache the file on both of its replicas, and somewhere it doesn't belong
+	replicas := cm.GetCaches(filename, 0)
+	for _, cacheID := range replicas {
+		cm.GetCache(cacheID).Fetch(filename, 0)
+	}
+	for cacheID := 0; cacheID < 4; cacheID++ {
+		if cacheID != replicas[0] && cacheID != replicas[1] {
+			cm.GetCache(cacheID).Fetch(filename, 0)
+			break
+		}
+	}
+
+	if invalidated := cm.Invalidate(filename); invalidated != len(replicas) {
+		t.Errorf("Expected %d replicas invalidated, got %d", len(replicas), invalidated)
+		failed = true
+	}
+	stats := cm.Report()
+	if stats.Invalidations != int64(len(replicas)) {
+		t.Errorf("Expected %d invalidations, got %d", len(replicas), stats.Invalidations)
+		failed = true
+	}
+	// the master sums the stats of every cache
+	if stats.Misses != int64(len(replicas)) + 1 || stats.Calls != stats.Misses {
+		t.Errorf("Expected %d misses and calls across caches, got %+v", len(replicas) + 1, stats)
+		failed = true
+	}
+
+	// files the hash doesn't know about are invalidated everywhere
+	cm.GetCache(0).Fetch("missing.txt", 0)
+	if invalidated := cm.Invalidate("missing.txt"); invalidated != 0 {
+		t.Errorf("Expected nothing to invalidate, got %d", invalidated)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestDeltaSync(t *testing.T) {
+	fmt.Printf("TestDeltaSync ...\n")
+	failed := false
+
+	data := MakeTestDatastore(8)
+	params := CacheParams[string]{
+		NCaches: 3,
+		RFactor: 1,
+		Prefetch: config.MarkovPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		CacheEntries: config.CACHE_SIZE,
+		Datastore: data,
+		Sync_ms: 0, // sync by hand
+	}
+	cm := MakeCacheMaster([]int{0, 1, 2}, params)
+	defer cm.Close()
+
+	// every sync only ships what changed, but the aggregate still counts everything once
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			cacheID := (i + round) % 3
+			cm.caches[cacheID].Fetch("fake_" + strconv.Itoa((i * (cacheID + 1)) % 8) + ".txt", cacheID)
+		}
+		cm.syncOnce()
+
+		rebuilt := markov.MakeMarkovChain()
+		for i := 0; i < 3; i++ {
+			rebuilt.Merge(cm.caches[i].LocalChain())
+		}
+		for j := 0; j < 8; j++ {
+			filename := "fake_" + strconv.Itoa(j) + ".txt"
+			expected, _ := rebuilt.BatchPredictProbable(filename, 3, 0)
+			received, _ := cm.caches[0].Predict(filename, 3)
+			if len(received) != len(expected) {
+				t.Errorf("Expected %v after %v in round %d, got %v", expected, filename, round, received)
+				failed = true
+				continue
+			}
+			for k := range received {
+				if received[k] != expected[k].Name {
+					t.Errorf("Expected %v after %v in round %d, got %v", expected, filename, round, received)
+					failed = true
+					break
+				}
+			}
+		}
+		if changes := cm.caches[round % 3].LocalChanges(); changes.Stats().Edges != 0 {
+			t.Errorf("Expected no changes right after a sync, got %+v", changes.Stats())
+			failed = true
+		}
+	}
+
+	// the aggregate is bounded like the caches' chains
+	params.Markov = markov.Options{MaxNodes: 6}
+	bounded := MakeCacheMaster([]int{0, 1, 2}, params)
+	defer bounded.Close()
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 40; i++ {
+			cacheID := i % 3
+			bounded.caches[cacheID].Fetch("fake_" + strconv.Itoa((i * 5 + round) % 8) + ".txt", cacheID)
+		}
+		bounded.syncOnce()
+		if stats := bounded.chain.Stats(); stats.Nodes > 6 {
+			t.Errorf("Expected at most 6 nodes in the aggregate in round %d, got %+v", round, stats)
+			failed = true
+			break
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestSnapshotSync(t *testing.T) {
+	fmt.Printf("TestSnapshotSync ...\n")
+	failed := false
+
+	// a chain learned before the caches came up
+	learned := markov.MakeMarkovChain()
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 8; j++ {
+			learned.RecordTransition("fake_" + strconv.Itoa(j) + ".txt", 0)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "chain")
+	if err := learned.SaveFile(path); err != nil {
+		t.Fatalf("Could not save snapshot: %v", err)
+	}
+
+	params := CacheParams[string]{
+		NCaches: 3,
+		RFactor: 1,
+		Prefetch: config.MarkovPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		CacheEntries: config.CACHE_SIZE,
+		Datastore: MakeTestDatastore(8),
+		Snapshot: path,
+		Sync_ms: 0, // sync by hand
+	}
+	cm := MakeCacheMaster([]int{0, 1, 2}, params)
+	defer cm.Close()
+
+	// every cache warm-started from it, but the aggregate counts it once
+	cm.syncOnce()
+	if changes := learned.Diff(cm.chain); changes.Stats().Edges != 0 {
+		t.Errorf("Expected the aggregate to count the snapshot once, got changes %+v", changes.Stats())
+		failed = true
+	}
+	for i := 0; i < 3; i++ {
+		if predict, err := cm.caches[i].Predict("fake_0.txt", 1); err != nil || len(predict) != 1 || predict[0] != "fake_1.txt" {
+			t.Errorf("Expected cache %d to keep its warm start across syncs, got %v: %v", i, predict, err)
+			failed = true
+		}
+	}
+	if errs := cm.Report().SnapshotErrors; errs != 0 {
+		t.Errorf("Expected every cache to load the snapshot, got %d errors", errs)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestSnapshotLoad(t *testing.T) {
+	fmt.Printf("TestSnapshotLoad ...\n")
+	failed := false
+
+	learned := markov.MakeMarkovChain()
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 4; j++ {
+			learned.RecordTransition("fake_" + strconv.Itoa(j) + ".txt", 0)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "chain")
+	if err := learned.SaveFile(path); err != nil {
+		t.Fatalf("Could not save snapshot: %v", err)
+	}
+
+	params := CacheParams[string]{
+		NCaches: 3,
+		RFactor: 1,
+		Prefetch: config.MarkovPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		CacheEntries: config.CACHE_SIZE,
+		Datastore: MakeTestDatastore(8),
+		Sync_ms: 5,
+	}
+	cm := MakeCacheMaster([]int{0, 1, 2}, params)
+	defer cm.Close()
+
+	// a cache that already saw some transitions loads the snapshot while syncing
+	for i := 0; i < 3; i++ {
+		cm.caches[0].Fetch("fake_5.txt", 0)
+		cm.caches[0].Fetch("fake_7.txt", 0)
+	}
+	if err := cm.caches[0].LoadSnapshot(path); err != nil {
+		t.Fatalf("Could not load snapshot: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// syncs spread it to every cache instead of undoing it, and keep what the cache saw
+	for i := 0; i < 3; i++ {
+		if predict, err := cm.caches[i].Predict("fake_0.txt", 1); err != nil || len(predict) != 1 || predict[0] != "fake_1.txt" {
+			t.Errorf("Expected cache %d to predict from the loaded snapshot, got %v: %v", i, predict, err)
+			failed = true
+		}
+		if predict, err := cm.caches[i].Predict("fake_5.txt", 1); err != nil || len(predict) != 1 || predict[0] != "fake_7.txt" {
+			t.Errorf("Expected cache %d to keep the observed transitions, got %v: %v", i, predict, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestReplicaPlacement(t *testing.T) {
+	fmt.Printf("TestReplicaPlacement ...\n")
+	failed := false
+
+	params := CacheParams[string]{
+		NCaches: 4,
+		RFactor: 2,
+		Prefetch: config.NoPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		CacheEntries: config.CACHE_SIZE,
+		Datastore: MakeTestDatastore(8),
+		WriteMode: config.WriteBack,
+	}
+	cm := MakeCacheMaster([]int{0, 1, 2}, params)
+	defer cm.Close()
+
+	// placing a new file leaves the other files' replicas and math/rand alone
+	orders := make(map[string][][]int)
+	for j := 0; j < 8; j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+		for id := 0; id < 3; id++ {
+			orders[filename] = append(orders[filename], cm.GetCaches(filename, id))
+		}
+	}
+	rand.Seed(7)
+	expected := rand.Int63()
+	rand.Seed(7)
+	if err := cm.Put("new.txt", "new"); err != nil {
+		t.Errorf("Could not write new.txt: %v", err)
+		failed = true
+	}
+	if received := rand.Int63(); received != expected {
+		t.Errorf("Expected placing a file not to reseed math/rand")
+		failed = true
+	}
+	for filename, order := range orders {
+		for id := 0; id < 3; id++ {
+			if received := cm.GetCaches(filename, id); fmt.Sprint(received) != fmt.Sprint(order[id]) {
+				t.Errorf("Expected %v for %v and client %d, got %v", order[id], filename, id, received)
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestReplicaWrites(t *testing.T) {
+	fmt.Printf("TestReplicaWrites ...\n")
+	failed := false
+
+	params := CacheParams[string]{
+		NCaches: 4,
+		RFactor: 2,
+		Prefetch: config.NoPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		CacheEntries: config.CACHE_SIZE,
+		Datastore: MakeTestDatastore(8),
+		WriteMode: config.WriteBack,
+	}
+	cm := MakeCacheMaster([]int{0, 1, 2}, params)
+	defer cm.Close()
+
+	// a replica that fails doesn't stop the others from being written
+	filename := "fake_0.txt"
+	replicas := cm.hash.GetCachesForFile(filename)
+	cm.GetCache(replicas[0]).Close()
+	err := cm.Put(filename, "written")
+	if err == nil || !strings.Contains(err.Error(), "replica " + strconv.Itoa(replicas[0])) {
+		t.Errorf("Expected replica %d to fail, got %v", replicas[0], err)
+		failed = true
+	}
+	if file, err := cm.GetCache(replicas[1]).Fetch(filename, 0); err != nil || file != "written" {
+		t.Errorf("Expected replica %d to be written, got %v, %v", replicas[1], file, err)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
@@ -1,8 +1,10 @@
-package cache_master
+package cachemaster
 
 import (
+    "hash/fnv"
     "math/rand"
-    "../config"
+    "sync"
+    "github.com/smart-cache/smart-cache-go/config"
 )
 
 /************************************************
@@ -22,10 +24,15 @@ Hash Function API
         clients - client IDs available
     GetCachesInGroup(groupID)
         Get the cache ids that are in a particular group
+    GetCachesForFile(file string) []int
+        Get every replica of a file, nil if the file is unknown
+    AddFile(file string) []int
+        Place a file that was created after the hash was made, returns its replicas
 
 ************************************************/
 
 type Hash struct {
+	mu              sync.RWMutex // protects files added after creation
 	NumGroups       int
 	clientIds       []int
 	fileGroups      map[string]int // map of file to column group
@@ -40,6 +47,8 @@ type Hash struct {
 API Useful to Client
 *************************************************************/
 func (h *Hash) GetCaches(file string, clientID int) []int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	return h.replicaOrder[file][clientID]
 }
 
@@ -58,7 +67,34 @@ func MakeHash(numCaches int, filenames []string, n int, replication int, clients
 }
 
 func (h *Hash) GetCachesInGroup(groupID int) []int {
-    return h.groupToCacheIDs[groupID]
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    return h.cachesInGroup(groupID)
+}
+
+func (h *Hash) GetCachesForFile(file string) []int {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    group, ok := h.fileGroups[file]
+    if !ok {
+        return nil
+    }
+    return h.cachesInGroup(group)
+}
+
+func (h *Hash) AddFile(file string) []int {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    group, ok := h.fileGroups[file]
+    if !ok {
+        // new files can't be spread evenly, so hash them to a group
+        hasher := fnv.New32a()
+        hasher.Write([]byte(file))
+        group = int(hasher.Sum32() % uint32(h.NumGroups))
+        h.fileGroups[file] = group
+        h.replicaOrder[file] = h.getCacheOrdersForFile(file)
+    }
+    return h.cachesInGroup(group)
 }
 
 /***********************************************************
@@ -92,14 +128,19 @@ func (h *Hash) getCacheOrdersForFile(file string) map[int][]int {
     caches := h.groupToCacheIDs[h.fileToGroup(file)]
     mapping := map[int][]int{}
     for i, clientId := range h.clientIds {
-        shuffled := shuffle(caches, i)
-        mapping[clientId] = make([]int, len(shuffled))
-        copy(mapping[clientId], shuffled)
+        mapping[clientId] = shuffle(caches, i)
     }
     return mapping
 }
 
 
+// copy, so callers can't reorder the group
+func (h *Hash) cachesInGroup(groupID int) []int {
+    caches := make([]int, len(h.groupToCacheIDs[groupID]))
+    copy(caches, h.groupToCacheIDs[groupID])
+    return caches
+}
+
 func (h *Hash) fileToGroup(filename string) int {
     return h.fileGroups[filename]
 }
@@ -136,12 +177,16 @@ func splitAmongstGroups(n int, numGroups int) []int {
 
 }
 
+// a shuffled copy of slice, the same for the same seed
+// seeds its own source, so other users of math/rand are left alone
 func shuffle(slice []int, seed int) []int {
-    rand.Seed(int64(seed))
-    rand.Shuffle(len(slice), func(i, j int) {
-        slice[i], slice[j] = slice[j], slice[i]
+    shuffled := make([]int, len(slice))
+    copy(shuffled, slice)
+    rng := rand.New(rand.NewSource(int64(seed)))
+    rng.Shuffle(len(shuffled), func(i, j int) {
+        shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
     })
-    return slice
+    return shuffled
 }
 
 func makeFileGroups(filenames []string, n int, numGroups int, seed int) map[string]int {
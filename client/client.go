@@ -0,0 +1,96 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/smart-cache/smart-cache-go/cachemaster"
+	"github.com/smart-cache/smart-cache-go/config"
+	"github.com/smart-cache/smart-cache-go/datastore"
+)
+
+/********************************
+Client supports the following external API to users
+MakeClient[V](id int, master *cachemaster.CacheMaster[V]) (* Client[V])
+	Initializes a client bound to the caches managed by master
+c.SetTimeout(timeout time.Duration)
+	How long to wait on a single replica before failing over to the next one
+c.Fetch(filename string) (V, int, error)
+	Fetches `filename`, trying its replicas in this client's order
+	Returns the data and the ID of the cache that served it
+	Fails with datastore.ErrNotFound at once if the file doesn't exist, other errors
+	(e.g. timeouts and closed caches) fail over to the next replica
+*********************************/
+
+var ErrNoReplicas = errors.New("no replicas for file")
+var ErrTimeout = errors.New("replica timed out")
+
+type Client[V any] struct {
+	id			int								// client ID, decides the order replicas are tried in
+	master		*cachemaster.CacheMaster[V]	// owner of the caches and the hash
+	timeout		time.Duration					// per replica timeout
+}
+
+type fetchResult[V any] struct {
+	file		V
+	err			error
+}
+
+func MakeClient[V any](id int, master *cachemaster.CacheMaster[V]) (* Client[V]) {
+	client := &Client[V]{
+		id: id,
+		master: master,
+		timeout: config.CLIENT_TIMEOUT,
+	}
+	return client
+}
+
+func (c *Client[V]) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+func (c *Client[V]) Fetch(filename string) (V, int, error) {
+	var file V
+	replicas := c.master.GetCaches(filename, c.id)
+	if len(replicas) == 0 {
+		return file, -1, fmt.Errorf("%w: %v", ErrNoReplicas, filename)
+	}
+
+	var err error
+	for _, cacheID := range replicas {
+		file, err = c.fetchFrom(cacheID, filename)
+		if err == nil {
+			return file, cacheID, nil
+		}
+		if errors.Is(err, datastore.ErrNotFound) {
+			// every replica reads the same datastore, so none of them has it
+			return file, -1, err
+		}
+		// otherwise fail over to the next replica
+	}
+	return file, -1, fmt.Errorf("all %d replicas failed for %v: %w", len(replicas), filename, err)
+}
+
+// fetches filename from a single replica, giving up after c.timeout
+func (c *Client[V]) fetchFrom(cacheID int, filename string) (V, error) {
+	var zero V
+	replica := c.master.GetCache(cacheID)
+	if replica == nil {
+		return zero, fmt.Errorf("%w: cache %d does not exist", ErrNoReplicas, cacheID)
+	}
+
+	// buffered so the fetch can finish even if we have stopped waiting
+	done := make(chan fetchResult[V], 1)
+	go func() {
+		file, err := replica.Fetch(filename, c.id)
+		done <- fetchResult[V]{file, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.file, result.err
+	case <-time.After(c.timeout):
+		return zero, fmt.Errorf("%w: cache %d after %v", ErrTimeout, cacheID, c.timeout)
+	}
+}
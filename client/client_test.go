@@ -0,0 +1,231 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"github.com/smart-cache/smart-cache-go/cache"
+	"github.com/smart-cache/smart-cache-go/cachemaster"
+	"github.com/smart-cache/smart-cache-go/config"
+	"github.com/smart-cache/smart-cache-go/datastore"
+)
+
+const NFILES = 8
+
+func MakeTestMaster(nCaches int, rFactor int, clientIDs []int) *cachemaster.CacheMaster[string] {
+	data := datastore.MakeDataStore[string]()
+	for j := 0; j < NFILES; j++ {
+		filename := "fake_" + strconv.Itoa(j) + ".txt"
+		data.Make(filename, filename)
+	}
+	params := cachemaster.CacheParams[string]{
+		NCaches: nCaches,
+		RFactor: rFactor,
+		Prefetch: config.NoPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		CacheEntries: config.CACHE_SIZE,
+		Datastore: data,
+	}
+	return cachemaster.MakeCacheMaster(clientIDs, params)
+}
+
+func TestClientFetch(t *testing.T) {
+	fmt.Printf("TestClientFetch ...\n")
+	failed := false
+
+	clientIDs := []int{0, 1, 2}
+	cm := MakeTestMaster(4, 2, clientIDs)
+	defer cm.Close()
+
+	for _, id := range clientIDs {
+		client := MakeClient(id, cm)
+		for j := 0; j < NFILES; j++ {
+			filename := "fake_" + strconv.Itoa(j) + ".txt"
+			file, cacheID, err := client.Fetch(filename)
+			if err != nil || file != filename {
+				t.Errorf("Client %d could not fetch %s: %v", id, filename, err)
+				failed = true
+			}
+			// with every replica up, the first one in the client's order serves
+			if replicas := cm.GetCaches(filename, id); cacheID != replicas[0] {
+				t.Errorf("Expected %s to be served by cache %d, got %d", filename, replicas[0], cacheID)
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	fmt.Printf("TestClientFailover ...\n")
+	failed := false
+
+	cm := MakeTestMaster(4, 2, []int{0})
+	defer cm.Close()
+	client := MakeClient(0, cm)
+
+	filename := "fake_0.txt"
+	replicas := cm.GetCaches(filename, 0)
+	if len(replicas) != 2 {
+		t.Fatalf("Expected 2 replicas, got %v", replicas)
+	}
+
+	// take down the preferred replica
+	cm.GetCache(replicas[0]).Close()
+
+	file, cacheID, err := client.Fetch(filename)
+	if err != nil || file != filename {
+		t.Errorf("Could not fetch %s after failover: %v", filename, err)
+		failed = true
+	}
+	if cacheID != replicas[1] {
+		t.Errorf("Expected failover to cache %d, got %d", replicas[1], cacheID)
+		failed = true
+	}
+
+	// and with every replica down the error is reported
+	cm.GetCache(replicas[1]).Close()
+	_, cacheID, err = client.Fetch(filename)
+	if !errors.Is(err, cache.ErrClosed) || cacheID != -1 {
+		t.Errorf("Expected ErrClosed with every replica down, got %v from %d", err, cacheID)
+		failed = true
+	}
+
+	// files missing from the shared datastore aren't looked for on every replica
+	var calls int64
+	missing := datastore.MakeLoaderStore(func(filename string) (string, error) {
+		atomic.AddInt64(&calls, 1)
+		return "", fmt.Errorf("%w: %v", datastore.ErrNotFound, filename)
+	}, []string{"gone.txt", "lost.txt"})
+	empty := cachemaster.MakeCacheMaster([]int{0}, cachemaster.CacheParams[string]{
+		NCaches: 4,
+		RFactor: 2,
+		Prefetch: config.NoPrefetch,
+		CacheSize: config.CACHE_BYTES,
+		Datastore: missing,
+	})
+	defer empty.Close()
+	_, cacheID, err = MakeClient(0, empty).Fetch("gone.txt")
+	if n := atomic.LoadInt64(&calls); !errors.Is(err, datastore.ErrNotFound) || cacheID != -1 || n != 1 {
+		t.Errorf("Expected ErrNotFound after one datastore call, got %v from %d after %d calls", err, cacheID, n)
+		failed = true
+	}
+
+	// files the hash doesn't know have no replicas
+	_, _, err = client.Fetch("missing.txt")
+	if !errors.Is(err, ErrNoReplicas) {
+		t.Errorf("Expected ErrNoReplicas, got %v", err)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	fmt.Printf("TestClientTimeout ...\n")
+	failed := false
+
+	cm := MakeTestMaster(2, 2, []int{0})
+	defer cm.Close()
+	client := MakeClient(0, cm)
+	// a miss has to go to the datastore, which takes longer than this
+	client.SetTimeout(config.DATA_FETCH_TIME / 2)
+
+	filename := "fake_0.txt"
+	replicas := cm.GetCaches(filename, 0)
+
+	// warm only the second replica, so the first one is slow
+	if _, err := cm.GetCache(replicas[1]).Fetch(filename, 0); err != nil {
+		t.Fatalf("Could not warm cache %d: %v", replicas[1], err)
+	}
+
+	// had the client waited on the first replica, it would have served
+	_, cacheID, err := client.Fetch(filename)
+	if err != nil || cacheID != replicas[1] {
+		t.Errorf("Expected cache %d to serve after timeout, got %d: %v", replicas[1], cacheID, err)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestReadAfterWrite(t *testing.T) {
+	fmt.Printf("TestReadAfterWrite ...\n")
+	failed := false
+
+	clientIDs := []int{0, 1, 2}
+	for _, mode := range []config.WriteMode{config.WriteThrough, config.WriteBack} {
+		data := datastore.MakeDataStore[string]()
+		for j := 0; j < NFILES; j++ {
+			filename := "fake_" + strconv.Itoa(j) + ".txt"
+			data.Make(filename, filename)
+		}
+		params := cachemaster.CacheParams[string]{
+			NCaches: 4,
+			RFactor: 2,
+			Prefetch: config.NoPrefetch,
+			CacheSize: config.CACHE_BYTES,
+			CacheEntries: config.CACHE_SIZE,
+			Datastore: data,
+			WriteMode: mode,
+		}
+		cm := cachemaster.MakeCacheMaster(clientIDs, params)
+
+		// every client reads every file first, so the old versions are cached
+		for _, id := range clientIDs {
+			client := MakeClient(id, cm)
+			for j := 0; j < NFILES; j++ {
+				client.Fetch("fake_" + strconv.Itoa(j) + ".txt")
+			}
+		}
+
+		for version := 1; version <= 2; version++ {
+			value := "v" + strconv.Itoa(version)
+			// overwrite an existing file, and create one the hash has never seen
+			for _, filename := range []string{"fake_0.txt", "new.txt"} {
+				if err := cm.Put(filename, value); err != nil {
+					t.Errorf("Mode %v: could not write %s: %v", mode, filename, err)
+					failed = true
+				}
+				for _, id := range clientIDs {
+					file, _, err := MakeClient(id, cm).Fetch(filename)
+					if err != nil || file != value {
+						t.Errorf("Mode %v: client %d read %v instead of %v: %v", mode, id, file, value, err)
+						failed = true
+					}
+				}
+			}
+		}
+
+		// once flushed the shared datastore has the last write
+		if err := cm.Close(); err != nil {
+			t.Errorf("Mode %v: could not close: %v", mode, err)
+			failed = true
+		}
+		if file, err := data.Get("new.txt"); err != nil || file != "v2" {
+			t.Errorf("Mode %v: expected v2 in the datastore, got %v: %v", mode, file, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
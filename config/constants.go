@@ -0,0 +1,50 @@
+package config
+
+import (
+	"time"
+)
+
+const CACHE_SIZE = 20
+const CACHE_BYTES = 1 << 20
+const PREFETCH_SIZE = 10
+const PREFETCH_SHARE = 0.25		// share of a cache that prefetched files can take before they are used
+const PREFETCH_WORKERS = 2		// goroutines prefetching for each cache
+const PREFETCH_QUEUE = 16		// prefetches waiting for a worker in each cache
+
+//const SEED = time.Now().UnixNano()
+const SEED = 1
+
+type PrefetchType int
+
+const (
+	NoPrefetch		PrefetchType = 0	// only fetch files when they are requested
+	MarkovPrefetch	PrefetchType = 1	// prefetch the files a markov chain predicts next
+)
+
+type EvictionType int
+
+const (
+	EvictLRU		EvictionType = 0	// least recently used
+	EvictLFU		EvictionType = 1	// least frequently used
+	EvictARC		EvictionType = 2	// adaptive replacement cache
+	Evict2Q			EvictionType = 3	// 2Q
+	EvictWTinyLFU	EvictionType = 4	// window TinyLFU
+)
+
+type DropPolicy int
+
+const (
+	DropOldest		DropPolicy = 0		// a full prefetch queue drops its oldest prefetch for the new one
+	DropNewest		DropPolicy = 1		// a full prefetch queue drops the new prefetch
+)
+
+type WriteMode int
+
+const (
+	WriteThrough	WriteMode = 0		// writes go to the datastore immediately
+	WriteBack		WriteMode = 1		// writes are cached and flushed later
+)
+
+const DATA_FETCH_TIME = time.Millisecond * 10
+const DATA_COST_TIME = time.Millisecond * 1
+const CLIENT_TIMEOUT = time.Millisecond * 500
\ No newline at end of file
@@ -0,0 +1,100 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+)
+
+/********************************************************
+Backend API
+Anything a cache can sit in front of, for values of type V.
+DataStore, DirStore and LoaderStore all implement it.
+Get(file string) (V, error)
+ - returns the data for the file, ErrNotFound if there is no such file
+GetBatch(files []string) ([]V, error)
+ - returns the data for every file, in order
+ - missing files are reported with a *MissingError, the rest are still returned
+Keys() []string
+ - returns the names of every file in the backend
+Size() int
+ - returns the number of files in the backend
+
+Backends that can be written to also implement Writer
+Put(file string, value V) error
+ - creates or overwrites the file
+********************************************************/
+
+var ErrReadOnly = errors.New("backend is read-only")
+
+type Backend[V any] interface {
+	Get(filename string) (V, error)
+	GetBatch(filenames []string) ([]V, error)
+	Keys() []string
+	Size() int
+}
+
+type Writer[V any] interface {
+	Put(filename string, value V) error
+}
+
+/********************************************************
+LoaderStore API
+Read-through backend for a user supplied loader function, read-only
+MakeLoaderStore[V](load LoadFunc[V], keys []string)
+ - every Get calls load, which should fail with ErrNotFound for missing files
+ - keys are the files the loader knows about (used to place files in caches)
+********************************************************/
+
+type LoadFunc[V any] func(filename string) (V, error)
+
+type LoaderStore[V any] struct {
+	load		LoadFunc[V]
+	keys		[]string
+}
+
+func MakeLoaderStore[V any](load LoadFunc[V], keys []string) *LoaderStore[V] {
+	l := &LoaderStore[V]{
+		load: load,
+		keys: make([]string, len(keys)),
+	}
+	copy(l.keys, keys)
+	return l
+}
+
+func (l *LoaderStore[V]) Get(filename string) (V, error) {
+	return l.load(filename)
+}
+
+func (l *LoaderStore[V]) GetBatch(filenames []string) ([]V, error) {
+	return getEach[V](l, filenames)
+}
+
+func (l *LoaderStore[V]) Keys() []string {
+	keys := make([]string, len(l.keys))
+	copy(keys, l.keys)
+	return keys
+}
+
+func (l *LoaderStore[V]) Size() int {
+	return len(l.keys)
+}
+
+// GetBatch for backends without a native batch operation
+// fails on the first error other than a missing file
+func getEach[V any](b Backend[V], filenames []string) ([]V, error) {
+	files := make([]V, len(filenames))
+	missing := make([]string, 0)
+	for i, name := range filenames {
+		file, err := b.Get(name)
+		if errors.Is(err, ErrNotFound) {
+			missing = append(missing, name)
+		} else if err != nil {
+			return files, fmt.Errorf("failed to fetch %v: %w", name, err)
+		}
+		files[i] = file
+	}
+	if len(missing) > 0 {
+		return files, &MissingError{Files: missing}
+	}
+	return files, nil
+}
@@ -0,0 +1,53 @@
+package datastore
+
+import (
+	"encoding/json"
+)
+
+/********************************************************
+Codec API
+Converts values of type V to and from the bytes kept in storage
+Encode(value V) ([]byte, error)
+Decode(content []byte) (V, error)
+BytesCodec, StringCodec and JSONCodec[V] cover the common cases
+********************************************************/
+
+type Codec[V any] interface {
+	Encode(value V) ([]byte, error)
+	Decode(content []byte) (V, error)
+}
+
+// stores raw bytes as they are
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(value []byte) ([]byte, error) {
+	return value, nil
+}
+
+func (BytesCodec) Decode(content []byte) ([]byte, error) {
+	return content, nil
+}
+
+// stores strings as their bytes
+type StringCodec struct{}
+
+func (StringCodec) Encode(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+func (StringCodec) Decode(content []byte) (string, error) {
+	return string(content), nil
+}
+
+// stores any value as JSON, e.g. structs
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Encode(value V) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[V]) Decode(content []byte) (V, error) {
+	var value V
+	err := json.Unmarshal(content, &value)
+	return value, err
+}
@@ -0,0 +1,148 @@
+package datastore
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+	"github.com/smart-cache/smart-cache-go/config"
+)
+/********************************************************
+DataStore API
+In-memory Backend for values of any type V, with a delay to approximate real storage
+MakeDataStore[V]()
+ - initializes an empty datastore
+Make(filename string, content V)
+ - stores content under filename
+Put(filename string, content V)
+ - stores content under filename, at the cost of a call to the datastore
+Size()
+ - returns the size (number of files) in the datastore
+Get(file string)
+ - returns the datastore in the file for the corresponding key
+ - fails with ErrNotFound if there is no such file
+GetBatch(files []string)
+ - returns the data for every file, in order
+ - if some files are missing, the rest are still returned alongside a
+   *MissingError listing the missing files
+********************************************************/
+
+var ErrNotFound = errors.New("file not found in datastore")
+
+// returned by GetBatch when only part of the batch could be found
+type MissingError struct {
+    Files   []string    // files that were not found
+}
+
+func (e *MissingError) Error() string {
+    return fmt.Sprintf("%d files not found in datastore: %v", len(e.Files), e.Files)
+}
+
+// a MissingError is an ErrNotFound, so errors.Is works on partial batches
+func (e *MissingError) Is(target error) bool {
+    return target == ErrNotFound
+}
+
+type DataStore[V any] struct {
+    mu      sync.Mutex
+    data    map[string]V
+    n       int
+    calls   int64
+}
+
+func (d *DataStore[V]) CountCalls() int64 {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    return d.calls
+}
+
+func (d *DataStore[V]) Keys() []string {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    filenames := make([]string, len(d.data))
+    i := 0
+    for f, _ := range d.data {
+        filenames[i] = f
+        i++
+    }
+    return filenames
+}
+
+func MakeDataStore[V any]() *DataStore[V] {
+    d := &DataStore[V]{}
+    d.data = make(map[string]V)
+    d.n = 0
+    d.calls = 0
+    return d
+}
+
+func (d *DataStore[V]) Size() int {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    return d.n
+}
+
+func (d *DataStore[V]) Get(filename string) (V, error) {
+    time.Sleep(config.DATA_FETCH_TIME)
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    data, ok := d.data[filename]
+    d.calls++
+    // approx time of fetching from underlying datastore
+    if !ok {
+        return data, fmt.Errorf("%w: %v", ErrNotFound, filename)
+    }
+    return data, nil
+}
+
+func (d *DataStore[V]) GetBatch(filenames []string) ([]V, error) {
+    time.Sleep(config.DATA_FETCH_TIME + config.DATA_COST_TIME * time.Duration(len(filenames)))
+    d.mu.Lock()
+	defer d.mu.Unlock()
+	files := make([]V, len(filenames))
+	missing := make([]string, 0)
+	for i, name := range filenames {
+		file, ok := d.data[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		files[i] = file
+	}
+    d.calls++
+    // approx time of fetching from underlying datastore
+    if len(missing) > 0 {
+        return files, &MissingError{Files: missing}
+    }
+    return files, nil
+}
+
+func (d *DataStore[V]) Make(filename string, content V) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    d.data[filename] = content
+    d.n = len(d.data)
+}
+
+func (d *DataStore[V]) Put(filename string, content V) error {
+    time.Sleep(config.DATA_FETCH_TIME)
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    d.data[filename] = content
+    d.n = len(d.data)
+    d.calls++
+    return nil
+}
+
+func (d *DataStore[V]) Copy() *DataStore[V] {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    c := &DataStore[V]{}
+    c.data = make(map[string]V)
+    for filename, content := range d.data {
+        c.data[filename] = content
+    }
+    c.n = len(c.data)
+    c.calls = 0
+    return c
+}
\ No newline at end of file
@@ -0,0 +1,159 @@
+package datastore
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "testing"
+)
+
+func TestDatastoreCopy(t *testing.T) {
+	fmt.Println("TestDatastoreCopy ...")
+	// make datastore
+    d := MakeDataStore[string]()
+
+    d.Make("1", "hi")
+    d.Make("2", "bye")
+    c := d.Copy()
+
+    first, _:= c.Get("1")
+    second, _ := c.Get("2")
+    if first != "hi" || second != "bye" {
+	    t.Errorf("FAILED copied datastore: %v, original: %v", c, d)
+
+    }
+
+}
+
+func TestDatastoreMissing(t *testing.T) {
+    fmt.Println("TestDatastoreMissing ...")
+    d := MakeDataStore[string]()
+
+    d.Make("1", "hi")
+    d.Make("3", "bye")
+
+    if _, err := d.Get("2"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("FAILED expected ErrNotFound for missing file, got %v", err)
+    }
+
+    files, err := d.GetBatch([]string{"1", "2", "3", "4"})
+    var missing *MissingError
+    if !errors.As(err, &missing) || !errors.Is(err, ErrNotFound) {
+        t.Fatalf("FAILED expected a MissingError for partial batch, got %v", err)
+    }
+    if len(missing.Files) != 2 || missing.Files[0] != "2" || missing.Files[1] != "4" {
+        t.Errorf("FAILED expected files [2 4] to be missing, got %v", missing.Files)
+    }
+    // found files are still returned
+    if files[0] != "hi" || files[2] != "bye" {
+        t.Errorf("FAILED partial batch: %v", files)
+    }
+
+    if _, err := d.GetBatch([]string{"1", "3"}); err != nil {
+        t.Errorf("FAILED expected complete batch, got %v", err)
+    }
+}
+
+func TestDirStore(t *testing.T) {
+    fmt.Println("TestDirStore ...")
+    dir := t.TempDir()
+
+    os.WriteFile(filepath.Join(dir, "1"), []byte("hi"), 0644)
+    os.WriteFile(filepath.Join(dir, "2"), []byte("bye"), 0644)
+    // subdirectories are not files
+    os.Mkdir(filepath.Join(dir, "sub"), 0755)
+    os.WriteFile(filepath.Join(dir, "sub", "3"), []byte("nested"), 0644)
+
+    var d Backend[string] = MakeDirStore[string](dir, StringCodec{})
+
+    keys := d.Keys()
+    sort.Strings(keys)
+    if d.Size() != 2 || len(keys) != 2 || keys[0] != "1" || keys[1] != "2" {
+        t.Errorf("FAILED expected keys [1 2], got %v", keys)
+    }
+
+    if first, err := d.Get("1"); err != nil || first != "hi" {
+        t.Errorf("FAILED reading file 1: %v, %v", first, err)
+    }
+
+    // nothing outside the directory can be read
+    for _, name := range []string{"3", "sub", "sub/3", "../" + filepath.Base(dir) + "/1", ""} {
+        if _, err := d.Get(name); err == nil {
+            t.Errorf("FAILED expected an error reading %q", name)
+        }
+    }
+
+    files, err := d.GetBatch([]string{"2", "missing"})
+    var missing *MissingError
+    if !errors.As(err, &missing) || len(missing.Files) != 1 || files[0] != "bye" {
+        t.Errorf("FAILED partial batch: %v, %v", files, err)
+    }
+}
+
+func TestLoaderStore(t *testing.T) {
+    fmt.Println("TestLoaderStore ...")
+    loads := 0
+    load := func(filename string) (string, error) {
+        loads++
+        if filename == "missing" {
+            return "", ErrNotFound
+        }
+        return "loaded " + filename, nil
+    }
+    broken := errors.New("storage is down")
+
+    var d Backend[string] = MakeLoaderStore(load, []string{"a", "b"})
+
+    if d.Size() != 2 || len(d.Keys()) != 2 {
+        t.Errorf("FAILED expected 2 keys, got %v", d.Keys())
+    }
+    if a, err := d.Get("a"); err != nil || a != "loaded a" {
+        t.Errorf("FAILED loading a: %v, %v", a, err)
+    }
+
+    files, err := d.GetBatch([]string{"a", "missing", "b"})
+    if !errors.Is(err, ErrNotFound) || files[0] != "loaded a" || files[2] != "loaded b" {
+        t.Errorf("FAILED partial batch: %v, %v", files, err)
+    }
+    if loads != 4 {
+        t.Errorf("FAILED expected 4 loads, got %d", loads)
+    }
+
+    // loader errors other than missing files fail the batch
+    d = MakeLoaderStore(func(filename string) (string, error) {
+        return "", broken
+    }, []string{"a"})
+    if _, err := d.GetBatch([]string{"a"}); !errors.Is(err, broken) {
+        t.Errorf("FAILED expected loader error, got %v", err)
+    }
+}
+
+type record struct {
+    Name    string
+    Count   int
+}
+
+func TestDirStoreCodecs(t *testing.T) {
+    fmt.Println("TestDirStoreCodecs ...")
+    dir := t.TempDir()
+
+    blob := []byte{0, 1, 2, 255}
+    os.WriteFile(filepath.Join(dir, "blob"), blob, 0644)
+    os.WriteFile(filepath.Join(dir, "record"), []byte(`{"Name": "a", "Count": 2}`), 0644)
+
+    bytes := MakeDirStore[[]byte](dir, BytesCodec{})
+    if content, err := bytes.Get("blob"); err != nil || string(content) != string(blob) {
+        t.Errorf("FAILED reading blob: %v, %v", content, err)
+    }
+
+    records := MakeDirStore[record](dir, JSONCodec[record]{})
+    if r, err := records.Get("record"); err != nil || r.Name != "a" || r.Count != 2 {
+        t.Errorf("FAILED decoding record: %v, %v", r, err)
+    }
+    // content that can't be decoded is an error, not a missing file
+    if _, err := records.Get("blob"); err == nil || errors.Is(err, ErrNotFound) {
+        t.Errorf("FAILED expected a decoding error, got %v", err)
+    }
+}
@@ -0,0 +1,82 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/********************************************************
+DirStore API
+Backend serving the regular files in a single directory
+MakeDirStore[V](dir string, codec Codec[V])
+ - file names are relative to dir, subdirectories are not served
+ - file contents are decoded into values of type V with codec
+Get(file string)
+ - reads the file from disk on every call
+Put(file string, value V)
+ - encodes value with codec and writes it to disk
+********************************************************/
+
+type DirStore[V any] struct {
+	dir			string
+	codec		Codec[V]
+}
+
+func MakeDirStore[V any](dir string, codec Codec[V]) *DirStore[V] {
+	return &DirStore[V]{dir: dir, codec: codec}
+}
+
+// whether filename names a file directly inside the directory
+func validName(filename string) bool {
+	return filepath.Base(filename) == filename && filename != "." && filename != ".."
+}
+
+func (d *DirStore[V]) Get(filename string) (V, error) {
+	var value V
+	// never read outside of the directory
+	if !validName(filename) {
+		return value, fmt.Errorf("%w: %v", ErrNotFound, filename)
+	}
+	content, err := os.ReadFile(filepath.Join(d.dir, filename))
+	if os.IsNotExist(err) {
+		return value, fmt.Errorf("%w: %v", ErrNotFound, filename)
+	} else if err != nil {
+		return value, err
+	}
+	return d.codec.Decode(content)
+}
+
+func (d *DirStore[V]) Put(filename string, value V) error {
+	// never write outside of the directory
+	if !validName(filename) {
+		return fmt.Errorf("invalid file name %q", filename)
+	}
+	content, err := d.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(d.dir, filename), content, 0644)
+}
+
+func (d *DirStore[V]) GetBatch(filenames []string) ([]V, error) {
+	return getEach[V](d, filenames)
+}
+
+func (d *DirStore[V]) Keys() []string {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return []string{}
+	}
+	filenames := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			filenames = append(filenames, entry.Name())
+		}
+	}
+	return filenames
+}
+
+func (d *DirStore[V]) Size() int {
+	return len(d.Keys())
+}
@@ -0,0 +1,103 @@
+package eviction
+
+// Adaptive Replacement Cache (Megiddo and Modha, FAST '03)
+// t1 holds keys seen once recently, t2 keys seen at least twice. b1 and b2
+// remember keys recently evicted from each, and hits on them move the target
+// size p of t1 towards whichever list would have kept the key.
+// The capacity c is the number of cached keys when the cache is full.
+type ARC struct {
+	t1			*keyList
+	t2			*keyList
+	b1			*keyList					// ghosts of t1
+	b2			*keyList					// ghosts of t2
+	p			int							// target size of t1
+	last		string						// last key added, evicted only if nothing else is cached
+	lastFromB2	bool						// whether last was a hit in b2
+}
+
+func MakeARC() *ARC {
+	return &ARC{
+		t1: makeKeyList(),
+		t2: makeKeyList(),
+		b1: makeKeyList(),
+		b2: makeKeyList(),
+	}
+}
+
+func (p *ARC) Add(key string) {
+	if p.t1.contains(key) || p.t2.contains(key) {
+		p.Access(key)
+		return
+	}
+
+	c := p.Len() + 1
+	p.lastFromB2 = false
+	if p.b1.contains(key) {
+		// t1 was too small to keep key, grow it
+		p.p = min(p.p + max(1, p.b2.len() / p.b1.len()), c)
+		p.b1.remove(key)
+		p.t2.pushFront(key)
+	} else if p.b2.contains(key) {
+		// t2 was too small to keep key, shrink t1
+		p.p = max(p.p - max(1, p.b1.len() / p.b2.len()), 0)
+		p.b2.remove(key)
+		p.t2.pushFront(key)
+		p.lastFromB2 = true
+	} else {
+		p.t1.pushFront(key)
+	}
+	p.last = key
+}
+
+func (p *ARC) Access(key string) {
+	if p.t1.remove(key) {
+		p.t2.pushFront(key)
+	} else {
+		p.t2.moveToFront(key)
+	}
+}
+
+func (p *ARC) Remove(key string) {
+	p.t1.remove(key)
+	p.t2.remove(key)
+}
+
+func (p *ARC) Evict() (string, bool) {
+	if p.Len() == 0 {
+		return "", false
+	}
+
+	fromT1 := p.t1.len() > 0 && (p.t1.len() > p.p || (p.lastFromB2 && p.t1.len() == p.p))
+	if !fromT1 && p.t2.len() == 0 {
+		fromT1 = true
+	}
+	// never evict the key that was just added while something else could go
+	if fromT1 && p.t1.len() == 1 && p.t1.contains(p.last) && p.t2.len() > 0 {
+		fromT1 = false
+	} else if !fromT1 && p.t2.len() == 1 && p.t2.contains(p.last) && p.t1.len() > 0 {
+		fromT1 = true
+	}
+
+	var victim string
+	if fromT1 {
+		victim, _ = p.t1.popBack()
+		p.b1.pushFront(victim)
+	} else {
+		victim, _ = p.t2.popBack()
+		p.b2.pushFront(victim)
+	}
+
+	// the cache is full, so what is left is its capacity
+	c := p.Len()
+	for p.t1.len() + p.b1.len() > c && p.b1.len() > 0 {
+		p.b1.popBack()
+	}
+	for p.Len() + p.b1.len() + p.b2.len() > 2 * c && p.b2.len() > 0 {
+		p.b2.popBack()
+	}
+	return victim, true
+}
+
+func (p *ARC) Len() int {
+	return p.t1.len() + p.t2.len()
+}
@@ -0,0 +1,238 @@
+package eviction
+
+import (
+	"testing"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/smart-cache/smart-cache-go/config"
+)
+
+var policyTypes = []config.EvictionType{config.EvictLRU, config.EvictLFU, config.EvictARC, config.Evict2Q, config.EvictWTinyLFU}
+
+var policyNames = map[config.EvictionType]string{
+	config.EvictLRU: "LRU",
+	config.EvictLFU: "LFU",
+	config.EvictARC: "ARC",
+	config.Evict2Q: "2Q",
+	config.EvictWTinyLFU: "W-TinyLFU",
+}
+
+// plays trace through a cache holding capacity keys, returns the hit ratio
+func simulate(t *testing.T, policy Policy, capacity int, trace []string) float64 {
+	cached := make(map[string]bool)
+	hits := 0
+	for _, key := range trace {
+		if cached[key] {
+			policy.Access(key)
+			hits++
+			continue
+		}
+		policy.Add(key)
+		cached[key] = true
+		if policy.Len() > capacity {
+			victim, ok := policy.Evict()
+			if !ok || !cached[victim] {
+				t.Fatalf("Evicted %q, which is not cached (ok = %v)", victim, ok)
+			}
+			delete(cached, victim)
+		}
+		if policy.Len() != len(cached) {
+			t.Fatalf("Policy tracks %d keys, %d are cached", policy.Len(), len(cached))
+		}
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+// a hot set that fits in the cache among rarely repeated cold keys,
+// interrupted by scans of keys never seen again
+func scanTrace() []string {
+	rng := rand.New(rand.NewSource(int64(config.SEED)))
+	trace := []string{}
+	scanned := 0
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 400; i++ {
+			if rng.Intn(4) == 0 {
+				trace = append(trace, "cold_" + strconv.Itoa(rng.Intn(5000)))
+			} else {
+				trace = append(trace, "hot_" + strconv.Itoa(rng.Intn(60)))
+			}
+		}
+		for i := 0; i < 150; i++ {
+			trace = append(trace, "scan_" + strconv.Itoa(scanned))
+			scanned++
+		}
+	}
+	return trace
+}
+
+// working sets that fit in the cache, replaced by a new one every phase
+func shiftingTrace() []string {
+	rng := rand.New(rand.NewSource(int64(config.SEED)))
+	trace := []string{}
+	for phase := 0; phase < 10; phase++ {
+		for i := 0; i < 2000; i++ {
+			trace = append(trace, "phase_" + strconv.Itoa(phase) + "_" + strconv.Itoa(rng.Intn(80)))
+		}
+	}
+	return trace
+}
+
+func hitRatios(t *testing.T, capacity int, trace []string) map[config.EvictionType]float64 {
+	ratios := make(map[config.EvictionType]float64)
+	for _, eType := range policyTypes {
+		ratios[eType] = simulate(t, MakePolicy(eType), capacity, trace)
+		fmt.Printf("\t%s hit ratio %.3f\n", policyNames[eType], ratios[eType])
+	}
+	return ratios
+}
+
+func TestPolicyBookkeeping(t *testing.T) {
+	fmt.Printf("TestPolicyBookkeeping ...\n")
+	failed := false
+
+	for _, eType := range policyTypes {
+		name := policyNames[eType]
+		policy := MakePolicy(eType)
+		if _, ok := policy.Evict(); ok {
+			t.Errorf("%s evicted from an empty policy", name)
+			failed = true
+		}
+
+		policy.Add("a")
+		policy.Add("b")
+		policy.Add("c")
+		policy.Add("a")
+		policy.Access("b")
+		if policy.Len() != 3 {
+			t.Errorf("%s tracks %d keys, expected 3", name, policy.Len())
+			failed = true
+		}
+
+		policy.Remove("b")
+		policy.Remove("missing")
+		if policy.Len() != 2 {
+			t.Errorf("%s tracks %d keys after Remove, expected 2", name, policy.Len())
+			failed = true
+		}
+
+		evicted := make(map[string]bool)
+		for policy.Len() > 0 {
+			victim, ok := policy.Evict()
+			if !ok || (victim != "a" && victim != "c") || evicted[victim] {
+				t.Errorf("%s evicted %q (ok = %v)", name, victim, ok)
+				failed = true
+				break
+			}
+			evicted[victim] = true
+		}
+	}
+
+	// the two simple policies have an exact order
+	lru := MakeLRU()
+	lfu := MakeLFU()
+	for _, key := range []string{"a", "b", "c"} {
+		lru.Add(key)
+	}
+	// the last key added is only evicted once nothing else is left
+	for _, key := range []string{"c", "b", "a"} {
+		lfu.Add(key)
+	}
+	lru.Access("a")
+	lfu.Access("a")
+	lfu.Access("b")
+	lfu.Access("a")
+	if victim, _ := lru.Evict(); victim != "b" {
+		t.Errorf("Expected LRU to evict 'b', got %q", victim)
+		failed = true
+	}
+	if victim, _ := lfu.Evict(); victim != "c" {
+		t.Errorf("Expected LFU to evict 'c', got %q", victim)
+		failed = true
+	}
+	if victim, _ := lfu.Evict(); victim != "b" {
+		t.Errorf("Expected LFU to evict 'b', got %q", victim)
+		failed = true
+	}
+	lfu.Add("d")
+	if victim, _ := lfu.Evict(); victim != "a" {
+		t.Errorf("Expected LFU to keep the 'd' it just added, evicted %q", victim)
+		failed = true
+	}
+	if victim, _ := lfu.Evict(); victim != "d" {
+		t.Errorf("Expected LFU to evict 'd' once it is all that is left, got %q", victim)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	fmt.Printf("TestScanResistance ...\n")
+	failed := false
+
+	ratios := hitRatios(t, 100, scanTrace())
+
+	// scans flush an LRU cache, every other policy keeps the hot set
+	for _, eType := range policyTypes[1:] {
+		if ratios[eType] <= ratios[config.EvictLRU] {
+			t.Errorf("Expected %s (%.3f) to beat LRU (%.3f) on scans", policyNames[eType], ratios[eType], ratios[config.EvictLRU])
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestShiftingWorkingSet(t *testing.T) {
+	fmt.Printf("TestShiftingWorkingSet ...\n")
+	failed := false
+
+	ratios := hitRatios(t, 100, shiftingTrace())
+
+	// LFU clings to old phases, the adaptive policies keep up with LRU
+	if ratios[config.EvictLFU] >= ratios[config.EvictLRU] {
+		t.Errorf("Expected LRU (%.3f) to beat LFU (%.3f) on a shifting working set", ratios[config.EvictLRU], ratios[config.EvictLFU])
+		failed = true
+	}
+	// because old counts never age, not because it turns new keys away
+	lfu := MakeLFU()
+	simulate(t, lfu, 100, shiftingTrace())
+	admitted := 0
+	for lfu.Len() > 0 {
+		if victim, _ := lfu.Evict(); strings.HasPrefix(victim, "phase_9_") {
+			admitted++
+		}
+	}
+	if admitted == 0 {
+		t.Errorf("Expected LFU to hold keys of the last phase")
+		failed = true
+	}
+	// W-TinyLFU only forgets old phases once its sketch ages, but it does forget
+	if ratios[config.EvictWTinyLFU] < 2 * ratios[config.EvictLFU] {
+		t.Errorf("Expected W-TinyLFU (%.3f) to adapt better than LFU (%.3f)", ratios[config.EvictWTinyLFU], ratios[config.EvictLFU])
+		failed = true
+	}
+	for _, eType := range []config.EvictionType{config.EvictARC, config.Evict2Q} {
+		if ratios[eType] < 0.9 * ratios[config.EvictLRU] {
+			t.Errorf("Expected %s (%.3f) to be close to LRU (%.3f)", policyNames[eType], ratios[eType], ratios[config.EvictLRU])
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
@@ -0,0 +1,119 @@
+package eviction
+
+import (
+	"container/list"
+)
+
+// least frequently used, ties broken by least recently used
+// keys are bucketed by access count, so every operation is O(1)
+type LFU struct {
+	counts		map[string]int				// key -> access count
+	elements	map[string]*list.Element	// key -> element in buckets[counts[key]]
+	buckets		map[int]*list.List			// count -> keys, most recent at the front
+	minCount	int							// lowest count with a non-empty bucket
+	last		string						// last key added, evicted only if nothing else is cached
+}
+
+func MakeLFU() *LFU {
+	return &LFU{
+		counts: make(map[string]int),
+		elements: make(map[string]*list.Element),
+		buckets: make(map[int]*list.List),
+	}
+}
+
+func (p *LFU) Add(key string) {
+	if _, ok := p.counts[key]; ok {
+		p.Access(key)
+		return
+	}
+	p.push(key, 1)
+	p.minCount = 1
+	p.last = key
+}
+
+func (p *LFU) Access(key string) {
+	count, ok := p.counts[key]
+	if !ok {
+		return
+	}
+	p.unlink(key)
+	p.push(key, count + 1)
+	if _, ok := p.buckets[p.minCount]; !ok {
+		p.minCount = count + 1
+	}
+}
+
+func (p *LFU) Remove(key string) {
+	if _, ok := p.counts[key]; !ok {
+		return
+	}
+	p.unlink(key)
+	delete(p.counts, key)
+	if _, ok := p.buckets[p.minCount]; !ok {
+		p.resetMin()
+	}
+}
+
+func (p *LFU) Evict() (string, bool) {
+	bucket, ok := p.buckets[p.minCount]
+	if !ok {
+		return "", false
+	}
+	victim := bucket.Back()
+	// never evict the key that was just added while something else could go
+	if victim.Value.(string) == p.last && p.Len() > 1 {
+		victim = victim.Prev()
+		if victim == nil {
+			victim = p.buckets[p.nextCount(p.minCount)].Back()
+		}
+	}
+	key := victim.Value.(string)
+	p.Remove(key)
+	return key, true
+}
+
+func (p *LFU) Len() int {
+	return len(p.counts)
+}
+
+func (p *LFU) push(key string, count int) {
+	bucket, ok := p.buckets[count]
+	if !ok {
+		bucket = list.New()
+		p.buckets[count] = bucket
+	}
+	p.counts[key] = count
+	p.elements[key] = bucket.PushFront(key)
+}
+
+// removes key from its bucket, dropping the bucket if it empties
+func (p *LFU) unlink(key string) {
+	count := p.counts[key]
+	bucket := p.buckets[count]
+	bucket.Remove(p.elements[key])
+	delete(p.elements, key)
+	if bucket.Len() == 0 {
+		delete(p.buckets, count)
+	}
+}
+
+func (p *LFU) resetMin() {
+	p.minCount = 0
+	for count := range p.buckets {
+		if p.minCount == 0 || count < p.minCount {
+			p.minCount = count
+		}
+	}
+}
+
+// lowest count above count with a non-empty bucket
+func (p *LFU) nextCount(count int) int {
+	next := 0
+	for c := range p.buckets {
+		if c > count && (next == 0 || c < next) {
+			next = c
+		}
+	}
+	return next
+}
@@ -0,0 +1,61 @@
+package eviction
+
+import (
+	"container/list"
+)
+
+// ordered set of keys, front is the most recently pushed
+type keyList struct {
+	order		*list.List
+	elements	map[string]*list.Element
+}
+
+func makeKeyList() *keyList {
+	return &keyList{
+		order: list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+func (l *keyList) contains(key string) bool {
+	_, ok := l.elements[key]
+	return ok
+}
+
+func (l *keyList) pushFront(key string) {
+	l.elements[key] = l.order.PushFront(key)
+}
+
+func (l *keyList) moveToFront(key string) {
+	if e, ok := l.elements[key]; ok {
+		l.order.MoveToFront(e)
+	}
+}
+
+func (l *keyList) remove(key string) bool {
+	e, ok := l.elements[key]
+	if ok {
+		l.order.Remove(e)
+		delete(l.elements, key)
+	}
+	return ok
+}
+
+func (l *keyList) back() (string, bool) {
+	if l.order.Len() == 0 {
+		return "", false
+	}
+	return l.order.Back().Value.(string), true
+}
+
+func (l *keyList) popBack() (string, bool) {
+	key, ok := l.back()
+	if ok {
+		l.remove(key)
+	}
+	return key, ok
+}
+
+func (l *keyList) len() int {
+	return l.order.Len()
+}
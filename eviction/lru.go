@@ -0,0 +1,41 @@
+package eviction
+
+import (
+	"github.com/smart-cache/smart-cache-go/heap"
+)
+
+// least recently used, keyed on a logical timestamp
+type LRU struct {
+	heap		*heap.MinHeapInt64
+	timestamp	int64
+}
+
+func MakeLRU() *LRU {
+	return &LRU{heap: heap.MakeMinHeapInt64()}
+}
+
+func (p *LRU) Add(key string) {
+	p.timestamp++
+	// also moves keys that are already tracked
+	p.heap.Insert(key, p.timestamp)
+}
+
+func (p *LRU) Access(key string) {
+	p.timestamp++
+	p.heap.ChangeKey(key, p.timestamp)
+}
+
+func (p *LRU) Remove(key string) {
+	p.heap.Remove(key)
+}
+
+func (p *LRU) Evict() (string, bool) {
+	if p.heap.Size == 0 {
+		return "", false
+	}
+	return p.heap.ExtractMin(), true
+}
+
+func (p *LRU) Len() int {
+	return int(p.heap.Size)
+}
@@ -0,0 +1,46 @@
+package eviction
+
+import (
+	"github.com/smart-cache/smart-cache-go/config"
+)
+
+/********************************
+Policy is how a cache chooses what to evict. The cache owns the data and its
+capacity; a policy only tracks the names of the files that are cached.
+p.Add(key string)
+	key was just inserted into the cache
+p.Access(key string)
+	key was hit in the cache
+p.Remove(key string)
+	key left the cache for any reason other than eviction (invalidated, expired)
+p.Evict() (string, bool)
+	Choose the next key to evict and stop tracking it, false if nothing is tracked
+	Only called when the cache is full, so policies size themselves relative to Len()
+p.Len() int
+	Number of keys tracked (i.e. cached)
+
+MakePolicy(t config.EvictionType) Policy
+	Make an empty policy of the given type (LRU, LFU, ARC, 2Q or W-TinyLFU)
+*********************************/
+
+type Policy interface {
+	Add(key string)
+	Access(key string)
+	Remove(key string)
+	Evict() (string, bool)
+	Len() int
+}
+
+func MakePolicy(t config.EvictionType) Policy {
+	switch t {
+	case config.EvictLFU:
+		return MakeLFU()
+	case config.EvictARC:
+		return MakeARC()
+	case config.Evict2Q:
+		return MakeTwoQ()
+	case config.EvictWTinyLFU:
+		return MakeWTinyLFU()
+	}
+	return MakeLRU()
+}
@@ -0,0 +1,173 @@
+package eviction
+
+import (
+	"hash/fnv"
+)
+
+// W-TinyLFU (Einziger, Friedman and Manes, ACM ToS '17)
+// New keys enter a small LRU window (1% of the cache). A key leaving the window
+// joins the main segmented LRU, but when that overflows the cache it is only
+// kept if a count-min sketch says it is more popular than the main victim.
+// The main segment is split into probation and protected (80%), and hits in
+// probation promote to protected.
+type WTinyLFU struct {
+	window		*keyList
+	probation	*keyList
+	protected	*keyList
+	sketch		*sketch
+	candidate	string						// last key to leave the window, not yet admitted
+}
+
+func MakeWTinyLFU() *WTinyLFU {
+	return &WTinyLFU{
+		window: makeKeyList(),
+		probation: makeKeyList(),
+		protected: makeKeyList(),
+		sketch: makeSketch(0),
+	}
+}
+
+func (p *WTinyLFU) Add(key string) {
+	if p.window.contains(key) || p.probation.contains(key) || p.protected.contains(key) {
+		p.Access(key)
+		return
+	}
+	p.sketch = p.sketch.fit(p.Len() + 1)
+	p.sketch.increment(key)
+	p.window.pushFront(key)
+	for p.window.len() > p.windowCap(p.Len()) {
+		p.candidate, _ = p.window.popBack()
+		p.probation.pushFront(p.candidate)
+	}
+}
+
+func (p *WTinyLFU) Access(key string) {
+	p.sketch.increment(key)
+	if p.probation.remove(key) {
+		p.protected.pushFront(key)
+		// keep protected within its share, demoting back to probation
+		c := p.Len()
+		protectedCap := max(1, (c - p.windowCap(c)) * 4 / 5)
+		for p.protected.len() > protectedCap {
+			demoted, _ := p.protected.popBack()
+			p.probation.pushFront(demoted)
+		}
+	} else {
+		p.window.moveToFront(key)
+		p.protected.moveToFront(key)
+	}
+}
+
+func (p *WTinyLFU) Remove(key string) {
+	p.window.remove(key)
+	p.probation.remove(key)
+	p.protected.remove(key)
+}
+
+func (p *WTinyLFU) Evict() (string, bool) {
+	victim, ok := p.mainVictim()
+	if !ok {
+		return p.window.popBack()
+	}
+
+	if p.probation.contains(p.candidate) && p.candidate != victim {
+		candidate := p.candidate
+		p.candidate = ""
+		if p.sketch.estimate(candidate) <= p.sketch.estimate(victim) {
+			// not popular enough to displace the victim
+			p.probation.remove(candidate)
+			return candidate, true
+		}
+	}
+	p.Remove(victim)
+	return victim, true
+}
+
+func (p *WTinyLFU) Len() int {
+	return p.window.len() + p.probation.len() + p.protected.len()
+}
+
+func (p *WTinyLFU) windowCap(c int) int {
+	return max(1, c / 100)
+}
+
+func (p *WTinyLFU) mainVictim() (string, bool) {
+	if victim, ok := p.probation.back(); ok {
+		return victim, true
+	}
+	return p.protected.back()
+}
+
+// count-min sketch of 4 bit counters (stored in bytes), halved periodically
+// so that popularity ages
+type sketch struct {
+	rows		[4][]uint8
+	mask		uint64
+	additions	int
+	sampleSize	int							// additions before every counter is halved, ~10 per key
+}
+
+const sketchMaxCount = 15
+
+// makes a sketch with room for about n keys
+func makeSketch(n int) *sketch {
+	width := 64
+	for width < 8 * n {
+		width *= 2
+	}
+	s := &sketch{
+		mask: uint64(width - 1),
+		sampleSize: width,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// returns a sketch with room for n keys, a new (empty) one if this one is too small
+func (s *sketch) fit(n int) *sketch {
+	if 4 * n > len(s.rows[0]) {
+		return makeSketch(n)
+	}
+	return s
+}
+
+func (s *sketch) indexes(key string) [4]uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	h := hasher.Sum64()
+	h1, h2 := h & 0xffffffff, h >> 32
+	var indexes [4]uint64
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i) * h2) & s.mask
+	}
+	return indexes
+}
+
+func (s *sketch) increment(key string) {
+	for i, index := range s.indexes(key) {
+		if s.rows[i][index] < sketchMaxCount {
+			s.rows[i][index]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] /= 2
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	estimate := uint8(sketchMaxCount)
+	for i, index := range s.indexes(key) {
+		if s.rows[i][index] < estimate {
+			estimate = s.rows[i][index]
+		}
+	}
+	return estimate
+}
@@ -0,0 +1,73 @@
+package eviction
+
+// 2Q (Johnson and Shasha, VLDB '94)
+// New keys enter the FIFO a1in. Keys evicted from a1in are remembered in the
+// ghost FIFO a1out, and only keys seen again while in a1out enter the LRU am,
+// so one-off scans never push out the working set.
+// a1in is kept to a quarter of the cache, a1out remembers half as many keys.
+type TwoQ struct {
+	a1in		*keyList
+	am			*keyList
+	a1out		*keyList					// ghosts of a1in
+	last		string						// last key added, evicted only if nothing else is cached
+}
+
+func MakeTwoQ() *TwoQ {
+	return &TwoQ{
+		a1in: makeKeyList(),
+		am: makeKeyList(),
+		a1out: makeKeyList(),
+	}
+}
+
+func (p *TwoQ) Add(key string) {
+	if p.a1in.contains(key) || p.am.contains(key) {
+		p.Access(key)
+		return
+	}
+	if p.a1out.remove(key) {
+		p.am.pushFront(key)
+	} else {
+		p.a1in.pushFront(key)
+	}
+	p.last = key
+}
+
+func (p *TwoQ) Access(key string) {
+	// hits in a1in are likely correlated references, so they don't promote
+	p.am.moveToFront(key)
+}
+
+func (p *TwoQ) Remove(key string) {
+	p.a1in.remove(key)
+	p.am.remove(key)
+}
+
+func (p *TwoQ) Evict() (string, bool) {
+	c := p.Len()
+	if c == 0 {
+		return "", false
+	}
+
+	fromA1in := p.a1in.len() > max(1, c / 4) || p.am.len() == 0
+	// never evict the key that was just added while something else could go
+	if !fromA1in && p.am.len() == 1 && p.am.contains(p.last) && p.a1in.len() > 0 {
+		fromA1in = true
+	}
+
+	if !fromA1in {
+		victim, _ := p.am.popBack()
+		return victim, true
+	}
+
+	victim, _ := p.a1in.popBack()
+	p.a1out.pushFront(victim)
+	for p.a1out.len() > max(1, (c - 1) / 2) {
+		p.a1out.popBack()
+	}
+	return victim, true
+}
+
+func (p *TwoQ) Len() int {
+	return p.a1in.len() + p.am.len()
+}
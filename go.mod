@@ -0,0 +1,3 @@
+module github.com/smart-cache/smart-cache-go
+
+go 1.21
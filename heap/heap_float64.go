@@ -25,12 +25,20 @@ func (h *MinHeapFloat64) Init() {
 	h.labels = make(map[string]int64)
 }
 
+// orders items by key, and equal keys by label so the order never depends on insertion
+func (h *MinHeapFloat64) less(i int64, j int64) bool {
+	if h.items[i].key != h.items[j].key {
+		return h.items[i].key < h.items[j].key
+	}
+	return h.items[i].label < h.items[j].label
+}
+
 func (h *MinHeapFloat64) MinHeapifyUp(c int64) {
 	if c == 0 {
 		return
 	}
 	p := (c - 1) / 2
-	if h.items[p].key > h.items[c].key {
+	if h.less(c, p) {
 		// swap terms
 		h.Swap(p, c)
 		h.labels[h.items[p].label] = p
@@ -56,13 +64,13 @@ func (h *MinHeapFloat64) MinHeapifyDown(p int64) {
 
 	// set child pointer
 	var c int64
-	if h.items[r].key > h.items[l].key {
+	if h.less(l, r) {
 		c = l
 	} else {
 		c = r
 	}
 
-	if h.items[p].key > h.items[c].key {
+	if h.less(c, p) {
 		// swap terms
 		h.Swap(p, c)
 		h.labels[h.items[p].label] = p
@@ -90,6 +90,25 @@ func (h *MinHeapInt64) ExtractMin() string {
 	return label
 }
 
+// removes label from the heap, if present
+func (h *MinHeapInt64) Remove(label string) {
+	index, ok := h.labels[label]
+	if !ok {
+		return
+	}
+	last := h.Size - 1
+	h.Swap(index, last)
+	h.labels[h.items[index].label] = index
+	delete(h.labels, label)
+	h.items = h.items[:last]
+	h.Size--
+	if index < h.Size {
+		// the moved item may need to go either way
+		h.MinHeapifyUp(index)
+		h.MinHeapifyDown(h.labels[h.items[index].label])
+	}
+}
+
 func (h *MinHeapInt64) ChangeKey(label string, key int64) {
 	index, ok := h.labels[label]
 	if ok {
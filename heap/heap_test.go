@@ -53,6 +53,39 @@ func TestBasicHeap(t *testing.T) {
 		failed = true
 	}
 
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestHeapRemove(t *testing.T) {
+	fmt.Printf("TestHeapRemove ...\n")
+	failed := false
+
+	heap := MakeMinHeapInt64()
+	for i, label := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		heap.Insert(label, int64(i))
+	}
+
+	heap.Remove("d")
+	heap.Remove("a")
+	heap.Remove("missing")
+	heap.Remove("g")
+
+	if heap.Size != 4 || heap.Contains("a") || heap.Contains("d") || heap.Contains("g") {
+		t.Errorf("Expected 4 items without a, d or g, got %v", heap.GetKeyList())
+		failed = true
+	}
+
+	for _, expected := range []string{"b", "c", "e", "f"} {
+		if label := heap.ExtractMin(); label != expected {
+			t.Errorf("Expected '%s', got %s", expected, label)
+			failed = true
+		}
+	}
+
 	if failed {
 		fmt.Printf("\t... FAILED\n")
 	} else {
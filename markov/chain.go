@@ -0,0 +1,702 @@
+package markov
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"math"
+	"sort"
+	"strings"
+	"github.com/smart-cache/smart-cache-go/heap"
+)
+
+var ErrInvalidPrefetchCount = errors.New("invalid prefetch count")
+var ErrUnknownFile = errors.New("file has never been accessed")
+
+// how a chain models and forgets transitions
+// the zero value is a first order chain that remembers every transition forever, without bounds
+type Options struct {
+	Order			int						// number of previous accesses predictions depend on, 1 if 0
+	Decay			float64					// in (0, 1), every node multiplies its counts by Decay before recording a transition
+	Window			int						// if > 0, every node only counts its last Window transitions
+	MaxNodes		int						// if > 0, the least recently touched nodes are dropped to stay within MaxNodes
+	MaxDegree		int						// if > 0, every node drops its least counted edge to stay within MaxDegree
+	ClientWeight	float64					// in (0, 1], if > 0 every client also gets a chain of its own transitions,
+											// weighted ClientWeight against the global chain in client predictions
+	MaxClients		int						// if > 0, only the chains of the MaxClients most recently active clients
+											// are kept, defaultMaxClients if 0 and MaxNodes > 0
+}
+
+// client chains kept by chains bounded by MaxNodes that don't set MaxClients,
+// so memory stays within (defaultMaxClients + 1) * MaxNodes nodes
+const defaultMaxClients = 16
+
+// a predicted file and the probability of the most likely path to it
+type Prediction struct {
+	Name			string
+	Probability		float64
+}
+
+// size of a chain, Bytes is a rough estimate of the memory it uses
+type Stats struct {
+	Nodes			int
+	Edges			int
+	Bytes			int64
+}
+
+// rough costs of the parts of a chain, in bytes
+const (
+	nodeBytes		= 200					// MarkovNode, its map entry and empty containers
+	edgeBytes		= 64					// MarkovEdge and its neighbors entry
+	stringBytes		= 16					// string header
+	contextBytes	= 80					// contexts and history map entries and slices
+)
+
+// separates the files of a context in node names
+const contextSep = "\x00"
+
+// mu only guards which nodes (and client chains) exist, every node guards its counts with its
+// own lock, so predictions and transitions between known files share mu and run concurrently
+// only transitions that add nodes, and whole-chain operations (Copy, Merge, ...), wait for them
+type MarkovChain struct {
+	nodes			map[string]*MarkovNode  // context (last 1 to order files) -> Node (with adjacencies)
+	history			*shardedMap[int]		// client ID -> last order accesses, oldest first
+	contexts		*shardedMap[string]		// filename -> most recent history ending in it
+	opts			Options
+	clients			map[int]*MarkovChain	// client ID -> chain of that client's transitions alone, if opts.ClientWeight > 0
+	tick			int64					// number of transitions recorded, orders node touches (atomic)
+	active			int64					// tick of the parent's last transition of this client, for client chains (atomic)
+	mu				sync.RWMutex			// write lock to add or remove nodes, read lock to use them
+}
+
+
+// creates empty node for the given name
+func MakeMarkovChain() *MarkovChain {
+	return MakeMarkovChainWithOptions(Options{})
+}
+
+// creates an empty chain of the given order that forgets transitions as opts says
+// decay and window can be combined, a transition then leaves the window with its decayed count
+func MakeMarkovChainWithOptions(opts Options) *MarkovChain {
+	if opts.Order < 1 {
+		opts.Order = 1
+	}
+	// create empty set of 
+	markov := &MarkovChain{
+		history: makeHistories(),
+		contexts: makeContexts(),
+		nodes: make(map[string]*MarkovNode),
+		opts: opts,
+	}
+	if opts.ClientWeight > 0 {
+		markov.clients = make(map[int]*MarkovChain)
+	}
+	// set default MC for first call to markov::Access() (for each client)
+	markov.nodes[""] = markov.makeNode("")
+	return markov
+}
+
+func (m *MarkovChain) Options() Options {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.opts
+}
+
+func (m *MarkovChain) makeNode(name string) *MarkovNode {
+	node := MakeMarkovNode(name)
+	node.configure(m.opts)
+	return node
+}
+
+// assumes write lock on m.mu is held, or a read lock if the node exists
+// the node for context, made if it doesn't exist yet
+func (m *MarkovChain) node(context string) *MarkovNode {
+	node, ok := m.nodes[context]
+	if !ok {
+		node = m.makeNode(context)
+		m.nodes[context] = node
+	}
+	return node
+}
+
+// assumes write lock on m.mu is held
+// drops the least recently touched nodes once there are more than MaxNodes, down to
+// three quarters of MaxNodes so the sort is amortized over many transitions
+// edges to dropped nodes are kept, they are only dropped by MaxDegree or decay
+func (m *MarkovChain) prune() {
+	limit := m.opts.MaxNodes
+	if limit <= 0 || len(m.nodes) <= limit {
+		return
+	}
+
+	nodes := make([]*MarkovNode, 0, len(m.nodes))
+	for name, node := range m.nodes {
+		// the start node is always needed
+		if name != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	// nodes touched together go in name order, so equal chains stay equal
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].touched != nodes[j].touched {
+			return nodes[i].touched < nodes[j].touched
+		}
+		return nodes[i].name < nodes[j].name
+	})
+
+	keep := limit * 3 / 4
+	if keep < 1 {
+		keep = 1
+	}
+	for _, node := range nodes[:len(m.nodes) - keep] {
+		m.drop(node.name)
+	}
+}
+
+// assumes write lock on m.mu is held
+// forgets the node, and the last context of its file if it is a file
+func (m *MarkovChain) drop(name string) {
+	delete(m.nodes, name)
+	if !strings.Contains(name, contextSep) {
+		m.contexts.delete(name)
+	}
+}
+
+// assumes write lock on m.mu is held
+// merges node into mine, dropping mine if that took away all its edges
+// (nodes that never had edges are kept, their files are known without them)
+func (m *MarkovChain) mergeNode(mine *MarkovNode, node *MarkovNode) {
+	had := mine.degree() > 0
+	mine.merge(node)
+	if had && mine.degree() == 0 && mine.name != "" {
+		m.drop(mine.name)
+	}
+}
+
+// number of nodes and edges, and an estimate of the memory they take
+// includes the chains of every client
+func (m *MarkovChain) Stats() Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var stats Stats
+	for name, node := range m.nodes {
+		edges, bytes := node.size()
+		stats.Nodes++
+		stats.Edges += edges
+		stats.Bytes += nodeBytes + int64(len(name)) + bytes
+	}
+	count := func(_ string, files []string) {
+		stats.Bytes += contextBytes + int64(len(files)) * stringBytes
+	}
+	m.contexts.each(count)
+	m.history.each(func(_ int, files []string) {
+		count("", files)
+	})
+	for _, client := range m.clients {
+		c := client.Stats()
+		stats.Nodes += c.Nodes
+		stats.Edges += c.Edges
+		stats.Bytes += c.Bytes
+	}
+	return stats
+}
+
+// the node name of a sequence of files, just the filename for a single file
+func contextKey(files []string) string {
+	return strings.Join(files, contextSep)
+}
+
+// the last file of a context
+func lastFile(context string) string {
+	return context[strings.LastIndex(context, contextSep) + 1:]
+}
+
+// assumes read lock on m.mu is held
+// the context after next follows context, backing off to the longest one that was seen
+func (m *MarkovChain) successor(context string, next string) string {
+	files := []string{next}
+	if context != "" {
+		files = append(strings.Split(context, contextSep), next)
+	}
+	if len(files) > m.opts.Order {
+		files = files[len(files) - m.opts.Order:]
+	}
+	for j := len(files); j > 1; j-- {
+		key := contextKey(files[len(files) - j:])
+		if _, ok := m.nodes[key]; ok {
+			return key
+		}
+	}
+	return next
+}
+
+// safe to call concurrently, transitions between known files only need the read lock
+// transitions of one client ID are expected one at a time, like the client's accesses
+func (m *MarkovChain) RecordTransition(filename string, id int) {
+	tick := atomic.AddInt64(&m.tick, 1)
+
+	m.mu.RLock()
+	client, ok := m.record(filename, id, tick, false)
+	m.mu.RUnlock()
+
+	if !ok {
+		// new nodes and client chains need the write lock
+		m.mu.Lock()
+		client, _ = m.record(filename, id, tick, true)
+		m.prune()
+		m.mu.Unlock()
+	}
+
+	if client != nil {
+		client.RecordTransition(filename, id)
+	}
+}
+
+// assumes read lock on m.mu is held, or write lock if write
+// records the transition if every node it needs exists (always if write), and returns
+// the chain of client id and whether the transition was recorded
+func (m *MarkovChain) record(filename string, id int, tick int64, write bool) (*MarkovChain, bool) {
+	history := m.history.get(id)
+
+	// every context of up to order files predicts filename
+	contexts := make([]string, 0, len(history) + 1)
+	if len(history) == 0 {
+		// this client ID's first access
+		contexts = append(contexts, "")
+	}
+	for j := 1; j <= len(history); j++ {
+		contexts = append(contexts, contextKey(history[len(history) - j:]))
+	}
+
+	client := m.clients[id]
+	if !write {
+		// check everything first, so nothing is recorded twice
+		if m.clients != nil && client == nil {
+			return nil, false
+		}
+		for _, context := range append(contexts, filename) {
+			if _, ok := m.nodes[context]; !ok {
+				return nil, false
+			}
+		}
+	} else if m.clients != nil && client == nil {
+		m.dropIdleClient()
+		opts := m.opts
+		opts.ClientWeight = 0
+		client = MakeMarkovChainWithOptions(opts)
+		m.clients[id] = client
+	}
+	if client != nil {
+		atomic.StoreInt64(&client.active, tick)
+	}
+
+	for _, context := range contexts {
+		node := m.node(context)
+		node.RecordTransition(filename)
+		node.touch(tick)
+	}
+
+	// check if file has own chain
+	m.node(filename).touch(tick)
+
+	start := 0
+	if len(history) >= m.opts.Order {
+		start = len(history) - m.opts.Order + 1
+	}
+	next := make([]string, 0, m.opts.Order)
+	next = append(next, history[start:]...)
+	next = append(next, filename)
+	m.history.set(id, next)
+	m.contexts.set(filename, next)
+	return client, true
+}
+
+// assumes write lock on m.mu is held
+// makes room for a new client chain by dropping that of the least recently active
+// client, whose transitions then only count in this chain
+func (m *MarkovChain) dropIdleClient() {
+	limit := m.opts.MaxClients
+	if limit <= 0 && m.opts.MaxNodes > 0 {
+		limit = defaultMaxClients
+	}
+	if limit <= 0 || len(m.clients) < limit {
+		return
+	}
+	idle, oldest := 0, int64(math.MaxInt64)
+	for id, client := range m.clients {
+		// lowest ID among equally idle clients, so equal chains stay equal
+		if active := atomic.LoadInt64(&client.active); active < oldest || (active == oldest && id < idle) {
+			idle, oldest = id, active
+		}
+	}
+	delete(m.clients, idle)
+}
+
+// returns a deep copy of the chain, including the last access and chain of every client
+func (m *MarkovChain) Copy() *MarkovChain {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &MarkovChain{
+		// histories are never modified in place, so they can be shared
+		history: m.history.copy(),
+		contexts: m.contexts.copy(),
+		nodes: make(map[string]*MarkovNode),
+		opts: m.opts,
+		tick: atomic.LoadInt64(&m.tick),
+		active: atomic.LoadInt64(&m.active),
+	}
+	for name, node := range m.nodes {
+		c.nodes[name] = node.Copy()
+	}
+	if m.clients != nil {
+		c.clients = make(map[int]*MarkovChain)
+		for id, client := range m.clients {
+			c.clients[id] = client.Copy()
+		}
+	}
+	return c
+}
+
+// adds the node and edge counts of other into this chain, dropping nodes left without edges
+// per-client last accesses and chains are not merged, they only make sense locally
+func (m *MarkovChain) Merge(other *MarkovChain) {
+	// copy first so the two chains are never locked at the same time
+	o := other.Copy()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, node := range o.nodes {
+		if mine, ok := m.nodes[name]; ok {
+			m.mergeNode(mine, node)
+		} else if !node.negative() {
+			mine := m.makeNode(name)
+			mine.touched = atomic.LoadInt64(&m.tick)
+			mine.merge(node)
+			m.nodes[name] = mine
+		}
+	}
+	m.prune()
+}
+
+// takes the node and edge counts of other out of this chain, e.g. to forget a chain
+// that was merged into it before
+// edges left with nothing are dropped, and so are nodes left without edges
+func (m *MarkovChain) Subtract(other *MarkovChain) {
+	o := other.Copy()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, node := range o.nodes {
+		if mine, ok := m.nodes[name]; ok {
+			node.negate()
+			m.mergeNode(mine, node)
+		}
+	}
+}
+
+// the changes to the counts of this chain since previous (an earlier copy of it),
+// which has negative counts where this chain forgot transitions
+// merging the result into previous, or into a chain previous was merged into, brings
+// it up to date with this chain, so syncs only need to ship the changes
+// the result is only meant to be merged, it has none of the last accesses or client chains
+func (m *MarkovChain) Diff(previous *MarkovChain) *MarkovChain {
+	p := previous.Copy()
+	c := m.Copy()
+	c.history = makeHistories()
+	c.contexts = makeContexts()
+	c.clients = nil
+
+	forgotten := make([]*MarkovNode, 0)
+	for name, old := range p.nodes {
+		if _, ok := c.nodes[name]; !ok {
+			// forgotten (e.g. pruned) since
+			old.negate()
+			forgotten = append(forgotten, old)
+		}
+	}
+
+	for name, node := range c.nodes {
+		old, ok := p.nodes[name]
+		if !ok {
+			// new nodes are kept even without edges, so their files become known
+			continue
+		}
+		node.subtract(old)
+		if len(node.adjacencies) == 0 {
+			delete(c.nodes, name)
+		}
+	}
+	for _, old := range forgotten {
+		c.nodes[old.name] = old
+	}
+	return c
+}
+
+// replaces the transition counts of this chain with a copy of model's
+// keeps the last access of every client so future transitions are recorded correctly,
+// and the chain of every client, which only this chain has seen
+// the copied counts decay with this chain's options, but are not in any window
+func (m *MarkovChain) Rebase(model *MarkovChain) {
+	c := model.Copy()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, node := range c.nodes {
+		node.configure(m.opts)
+		// nodes this chain knows stay as recent as they were here, so pruning
+		// keeps what it uses; new ones keep their order in the model, older
+		// than anything here since the model counts ticks on its own clock
+		if mine, ok := m.nodes[name]; ok {
+			node.touched = mine.touched
+		} else {
+			node.touched -= c.tick + 1
+		}
+	}
+	m.nodes = c.nodes
+	if _, ok := m.nodes[""]; !ok {
+		m.nodes[""] = m.makeNode("")
+	}
+	m.history.each(func(_ int, history []string) {
+		m.node(history[len(history) - 1])
+	})
+	m.prune()
+}
+
+// predict the next n files after filename is accessed
+// predictions start from the most recent context ending in filename, backing off
+// to shorter contexts (down to filename alone) until one has been seen before
+// fails with ErrInvalidPrefetchCount if n < 0, and ErrUnknownFile if filename was never recorded
+// (or its node was pruned to stay within MaxNodes)
+func (m *MarkovChain) BatchPredict(filename string, n int) ([]string, error) {
+	// transitions keep being recorded while Dijkstra's runs, each node is locked as it is read
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if n < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPrefetchCount, n)
+	}
+	// run Dijkstra's and return the results
+	predictions, err := m.longPaths(m.sourceContext(filename), n, 0)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(predictions))
+	for i, prediction := range predictions {
+		files[i] = prediction.Name
+	}
+	return files, nil
+}
+
+// like BatchPredict, but only files reached with at least threshold probability,
+// along with that probability
+// fails with ErrInvalidPrefetchCount if n < 0, and ErrUnknownFile if filename was never recorded
+func (m *MarkovChain) BatchPredictProbable(filename string, n int, threshold float64) ([]Prediction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if n < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPrefetchCount, n)
+	}
+	return m.longPaths(m.sourceContext(filename), n, threshold)
+}
+
+// assumes read lock on m.mu is held
+// the longest context ending in filename that has been followed by something
+func (m *MarkovChain) sourceContext(filename string) string {
+	return m.longestContext(m.contexts.get(filename), filename)
+}
+
+// assumes read lock on m.mu is held
+// like sourceContext, but from the last accesses of clientID if filename was the last,
+// since other clients may have accessed filename after different files
+func (m *MarkovChain) clientContext(filename string, clientID int) string {
+	history := m.history.get(clientID)
+	if len(history) == 0 || history[len(history) - 1] != filename {
+		return m.sourceContext(filename)
+	}
+	return m.longestContext(history, filename)
+}
+
+// assumes read lock on m.mu is held
+// the longest known context with transitions among the last files of context (which ends
+// in filename), filename alone if there is none
+func (m *MarkovChain) longestContext(context []string, filename string) string {
+	for j := len(context); j > 1; j-- {
+		key := contextKey(context[len(context) - j:])
+		if node, ok := m.nodes[key]; ok && node.degree() > 0 {
+			return key
+		}
+	}
+	return filename
+}
+
+// Observe and Predict let a chain be used as a cache's prefetcher
+func (m *MarkovChain) Observe(filename string, clientID int) {
+	m.RecordTransition(filename, clientID)
+}
+
+func (m *MarkovChain) Predict(filename string, n int) ([]string, error) {
+	return m.BatchPredict(filename, n)
+}
+
+func (m *MarkovChain) PredictProbable(filename string, n int, threshold float64) ([]Prediction, error) {
+	return m.BatchPredictProbable(filename, n, threshold)
+}
+
+func (m *MarkovChain) PredictClient(filename string, clientID int, n int, threshold float64) ([]Prediction, error) {
+	return m.BatchPredictClientProbable(filename, clientID, n, threshold)
+}
+
+// predict the next n files after clientID accesses filename
+// the probability of a file mixes clientID's own chain, weighted ClientWeight, with the
+// global chain, files are only considered if they are in the top n of either chain
+// same as BatchPredict without ClientWeight, or if clientID never accessed filename
+// fails with ErrInvalidPrefetchCount if n < 0, and ErrUnknownFile if no chain knows filename
+func (m *MarkovChain) BatchPredictClient(filename string, clientID int, n int) ([]string, error) {
+	predictions, err := m.BatchPredictClientProbable(filename, clientID, n, 0)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(predictions))
+	for i, prediction := range predictions {
+		files[i] = prediction.Name
+	}
+	return files, nil
+}
+
+// like BatchPredictClient, but only files with at least threshold mixed probability,
+// along with that probability
+func (m *MarkovChain) BatchPredictClientProbable(filename string, clientID int, n int, threshold float64) ([]Prediction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if n < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPrefetchCount, n)
+	}
+
+	source := m.clientContext(filename, clientID)
+	client, ok := m.clients[clientID]
+	if !ok {
+		return m.longPaths(source, n, threshold)
+	}
+	// client chains never lock their parent, so this can't deadlock
+	own, err := client.BatchPredictProbable(filename, n, 0)
+	if err != nil {
+		// the client has no idea what follows filename
+		return m.longPaths(source, n, threshold)
+	}
+	weight := math.Min(m.opts.ClientWeight, 1)
+	global, err := m.longPaths(source, n, 0)
+	if err != nil {
+		// pruned or rebased away, only the client knows what follows filename
+		weight = 1
+	}
+
+	mixed := make(map[string]float64)
+	for _, prediction := range global {
+		mixed[prediction.Name] += (1 - weight) * prediction.Probability
+	}
+	for _, prediction := range own {
+		mixed[prediction.Name] += weight * prediction.Probability
+	}
+
+	predictions := make([]Prediction, 0, len(mixed))
+	for name, probability := range mixed {
+		if probability >= threshold && probability > 0 {
+			predictions = append(predictions, Prediction{name, probability})
+		}
+	}
+	sort.Slice(predictions, func(i, j int) bool {
+		if predictions[i].Probability != predictions[j].Probability {
+			return predictions[i].Probability > predictions[j].Probability
+		}
+		return predictions[i].Name < predictions[j].Name
+	})
+	if len(predictions) > n {
+		predictions = predictions[:n]
+	}
+	return predictions, nil
+}
+
+// Find highest probabilities from the source context
+// paths go through contexts, so the k-th file predicted depends on the files before it
+// the probability of a file is that of the most likely path to it
+// CANNOT predict source as likely to be fetched again, and never predicts unreachable files
+// stops at the first file less likely than threshold
+// return order likelihood order, equally likely files in order of their contexts' names
+func (m *MarkovChain) longPaths(sourceContext string, n int, threshold float64) ([]Prediction, error) {
+	source := lastFile(sourceContext)
+
+	src_node, ok := m.nodes[sourceContext]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownFile, source)
+	}
+
+	// set up min weights
+	// weights are the negated log of the edge ratio -> min path weight becomes max product (max probability)
+	distances := make(map[string]float64)
+	distances[sourceContext] = 0
+
+	// store removed nodes so we can fetch the closest values and check if something has been removed
+	removed_nodes := make(map[string]bool)
+	closest_files := make([]Prediction, 0)
+	predicted := make(map[string]bool)		// a file can be reached through several contexts
+	nRemoved := 0
+
+	// store current guesses, only ever reachable contexts
+	queue := heap.MakeMinHeapFloat64()
+
+	// relax all edges from source
+	removed_nodes[sourceContext] = true
+	m.relax(sourceContext, src_node, 0, distances, removed_nodes, queue)
+
+	// now run Dijkstra's
+	for queue.Size > 0 && nRemoved < n {
+		name := queue.ExtractMin()
+		estimate := distances[name]
+		probability := math.Exp(-estimate)
+		if probability < threshold {
+			// everything left is even less likely
+			break
+		}
+		removed_nodes[name] = true
+
+		// this file is close in probability, so remove it from valid candidates
+		if file := lastFile(name); file != source && !predicted[file] {
+			predicted[file] = true
+			closest_files = append(closest_files, Prediction{file, probability})
+			nRemoved++
+		}
+
+		if node, ok := m.nodes[name]; ok {
+			// pruned nodes have nothing known about what follows them
+			m.relax(name, node, estimate, distances, removed_nodes, queue)
+		}
+	}
+	return closest_files, nil
+}
+
+// assumes read lock on m.mu is held
+// tries to improve the weight estimate of every context following context
+func (m *MarkovChain) relax(context string, node *MarkovNode, estimate float64, distances map[string]float64, removed map[string]bool, queue *heap.MinHeapFloat64) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	for _, transition := range node.adjacencies {
+		next := m.successor(context, transition.name)
+		if removed[next] {
+			// already has its final weight
+			continue
+		}
+		weight := estimate - math.Log(transition.count / node.count)
+		if current, ok := distances[next]; !ok {
+			distances[next] = weight
+			queue.Insert(next, weight)
+		} else if weight < current {
+			distances[next] = weight
+			queue.ChangeKey(next, weight)
+		}
+	}
+}
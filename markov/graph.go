@@ -0,0 +1,340 @@
+package markov
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Individual edge in the markov graph
+// count represents frequency, decayed counts are fractional
+type MarkovEdge struct {
+	count			float64
+	name			string
+}
+
+// edges whose count decays below this are dropped
+const minCount = 1e-3
+
+// changes to a count within this of 0 are rounding errors, not changes
+const diffEpsilon = 1e-9
+
+// sparse representation of adjacencies. double space for efficient lookups + iteration
+type MarkovNode struct {
+	name			string
+	count			float64					// sum of the counts of adjacencies
+	adjacencies		[]MarkovEdge			// fast iterator 
+	neighbors		map[string]int			// filename -> index in adjacencies. fast lookup of edge weights
+	decay			float64					// counts are multiplied by decay before each transition, 1 for no decay
+	window			int						// only the last window transitions are counted, 0 for all of them
+	recent			[]string				// ring of the last window transitions recorded
+	oldest			int						// index of the oldest transition in recent once it is full
+	maxDegree		int						// at most this many edges, the least counted goes first, 0 for no limit
+	touched			int64					// when the chain last recorded a transition from or to this node
+	mu				sync.Mutex				// for concurrent requests
+}
+
+
+// creates empty node for the given name
+func MakeMarkovNode(name string) *MarkovNode {
+	node := &MarkovNode{
+		name: name, 
+		count: 0, 
+		adjacencies: make([]MarkovEdge, 0), 
+		neighbors: make(map[string]int),
+		decay: 1,
+	}
+	return node
+}
+
+// sets how this node forgets old transitions, forgetting what it recorded so far
+// counts already in the node (e.g. merged from other chains) only decay, they never leave the window
+func (mn *MarkovNode) configure(opts Options) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+
+	mn.decay = 1
+	if opts.Decay > 0 && opts.Decay < 1 {
+		mn.decay = opts.Decay
+	}
+	mn.window = 0
+	if opts.Window > 0 {
+		mn.window = opts.Window
+	}
+	mn.recent = nil
+	mn.oldest = 0
+	mn.maxDegree = 0
+	if opts.MaxDegree > 0 {
+		mn.maxDegree = opts.MaxDegree
+		mn.trim()
+	}
+}
+
+func (mn *MarkovNode) RecordTransition(filename string) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+
+	if mn.decay < 1 {
+		mn.scale(mn.decay)
+	}
+	mn.add(filename, 1)
+
+	if mn.window == 0 {
+		return
+	}
+	if len(mn.recent) < mn.window {
+		mn.recent = append(mn.recent, filename)
+		return
+	}
+	// the oldest transition leaves the window, having decayed once per transition since
+	expired := mn.recent[mn.oldest]
+	mn.recent[mn.oldest] = filename
+	mn.oldest = (mn.oldest + 1) % mn.window
+	mn.add(expired, -math.Pow(mn.decay, float64(mn.window)))
+}
+
+// marks the node as used by the transition at tick
+func (mn *MarkovNode) touch(tick int64) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	mn.touched = tick
+}
+
+// number of transitions known to follow this node
+func (mn *MarkovNode) degree() int {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	return len(mn.adjacencies)
+}
+
+// number of edges, and an estimate of the memory they and the window take
+func (mn *MarkovNode) size() (int, int64) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+
+	bytes := int64(len(mn.recent)) * stringBytes
+	for _, edge := range mn.adjacencies {
+		bytes += edgeBytes + int64(len(edge.name))
+	}
+	return len(mn.adjacencies), bytes
+}
+
+// assumes lock on mn.mu is held
+// adds delta to the transition to filename, dropping the edge if its count runs out
+func (mn *MarkovNode) add(filename string, delta float64) {
+	neighbor, ok := mn.neighbors[filename]
+
+	if ok {
+		// already have edge to this node
+		mn.adjacencies[neighbor].count += delta
+		mn.count += delta
+		if mn.adjacencies[neighbor].count < minCount {
+			mn.count -= mn.adjacencies[neighbor].count
+			mn.removeEdge(neighbor)
+		}
+	} else if delta > 0 {
+		if mn.maxDegree > 0 && len(mn.adjacencies) >= mn.maxDegree {
+			// make room by forgetting the rarest transition
+			mn.removeMin()
+		}
+		// don't have edge, must make one
+		var e MarkovEdge
+		e.count = delta 		// first time seeing this transition
+		e.name = filename
+
+		// set index in map and append to end of list
+		mn.neighbors[filename] = len(mn.adjacencies)
+		mn.adjacencies = append(mn.adjacencies, e)
+		mn.count += delta
+	}
+}
+
+// assumes lock on mn.mu is held
+// multiplies every count by factor, dropping edges that become negligible
+func (mn *MarkovNode) scale(factor float64) {
+	mn.count = 0
+	for i := 0; i < len(mn.adjacencies); {
+		mn.adjacencies[i].count *= factor
+		if mn.adjacencies[i].count < minCount {
+			// the last edge takes its place, so look at i again
+			mn.removeEdge(i)
+			continue
+		}
+		mn.count += mn.adjacencies[i].count
+		i++
+	}
+}
+
+// assumes lock on mn.mu is held
+// drops the least counted edges until the node is within maxDegree
+func (mn *MarkovNode) trim() {
+	for mn.maxDegree > 0 && len(mn.adjacencies) > mn.maxDegree {
+		mn.removeMin()
+	}
+}
+
+// assumes lock on mn.mu is held
+func (mn *MarkovNode) removeMin() {
+	least := 0
+	for i, edge := range mn.adjacencies {
+		if edge.count < mn.adjacencies[least].count {
+			least = i
+		}
+	}
+	mn.count -= mn.adjacencies[least].count
+	mn.removeEdge(least)
+}
+
+// assumes lock on mn.mu is held
+// removes the edge at index, moving the last edge into its place, along with its
+// transitions in the window so they never expire from an edge added again later
+// the caller accounts for its count
+func (mn *MarkovNode) removeEdge(index int) {
+	edge := mn.adjacencies[index]
+	last := len(mn.adjacencies) - 1
+	mn.adjacencies[index] = mn.adjacencies[last]
+	mn.neighbors[mn.adjacencies[index].name] = index
+	mn.adjacencies = mn.adjacencies[:last]
+	delete(mn.neighbors, edge.name)
+
+	recent := make([]string, 0, len(mn.recent))
+	for i := range mn.recent {
+		// oldest first, so the window is no longer a ring until it fills up again
+		name := mn.recent[(mn.oldest + i) % len(mn.recent)]
+		if name != edge.name {
+			recent = append(recent, name)
+		}
+	}
+	if len(recent) < len(mn.recent) {
+		mn.recent = recent
+		mn.oldest = 0
+	}
+}
+
+// returns a deep copy of this node and its edges
+func (mn *MarkovNode) Copy() *MarkovNode {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+
+	node := MakeMarkovNode(mn.name)
+	node.count = mn.count
+	node.decay = mn.decay
+	node.window = mn.window
+	node.recent = append([]string(nil), mn.recent...)
+	node.oldest = mn.oldest
+	node.maxDegree = mn.maxDegree
+	node.touched = mn.touched
+	for _, edge := range mn.adjacencies {
+		node.neighbors[edge.name] = len(node.adjacencies)
+		node.adjacencies = append(node.adjacencies, edge)
+	}
+	return node
+}
+
+// adds the transition counts of other into this node
+// counts may be negative (see MarkovChain.Diff), edges left with nothing are dropped
+// assumes other is not shared (i.e. is a copy)
+func (mn *MarkovNode) merge(other *MarkovNode) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+
+	for _, edge := range other.adjacencies {
+		if neighbor, ok := mn.neighbors[edge.name]; ok {
+			mn.adjacencies[neighbor].count += edge.count
+		} else if edge.count > 0 {
+			mn.neighbors[edge.name] = len(mn.adjacencies)
+			mn.adjacencies = append(mn.adjacencies, edge)
+		}
+	}
+	// recounts, dropping the edges that were taken away
+	mn.scale(1)
+	mn.trim()
+}
+
+// flips the sign of every count, so merging this node takes its counts away
+// assumes mn is not shared (i.e. is a copy)
+func (mn *MarkovNode) negate() {
+	mn.count = -mn.count
+	for i := range mn.adjacencies {
+		mn.adjacencies[i].count = -mn.adjacencies[i].count
+	}
+}
+
+// whether merging this node can only take counts away
+func (mn *MarkovNode) negative() bool {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+
+	for _, edge := range mn.adjacencies {
+		if edge.count > 0 {
+			return false
+		}
+	}
+	return len(mn.adjacencies) > 0
+}
+
+// takes the counts of previous out of this node, keeping negative counts so the
+// result can be merged to undo them, and dropping edges that didn't change
+// assumes neither node is shared (i.e. they are copies)
+func (mn *MarkovNode) subtract(previous *MarkovNode) {
+	for _, edge := range previous.adjacencies {
+		if neighbor, ok := mn.neighbors[edge.name]; ok {
+			mn.adjacencies[neighbor].count -= edge.count
+		} else {
+			mn.neighbors[edge.name] = len(mn.adjacencies)
+			mn.adjacencies = append(mn.adjacencies, MarkovEdge{count: -edge.count, name: edge.name})
+		}
+	}
+	mn.count = 0
+	for i := 0; i < len(mn.adjacencies); {
+		if math.Abs(mn.adjacencies[i].count) <= diffEpsilon {
+			mn.removeEdge(i)
+			continue
+		}
+		mn.count += mn.adjacencies[i].count
+		i++
+	}
+}
+
+// the counts and window of this node, for snapshots
+func (mn *MarkovNode) snapshot() nodeSnapshot {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+
+	s := nodeSnapshot{
+		Name: mn.name,
+		Count: mn.count,
+		Recent: append([]string(nil), mn.recent...),
+		Oldest: mn.oldest,
+		Touched: mn.touched,
+	}
+	for _, edge := range mn.adjacencies {
+		s.Edges = append(s.Edges, edgeSnapshot{edge.name, edge.count})
+	}
+	return s
+}
+
+// replaces the counts and window of this (configured) node with those of s
+func (mn *MarkovNode) restore(s nodeSnapshot) error {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+
+	if len(s.Recent) > mn.window || (s.Oldest != 0 && s.Oldest >= len(s.Recent)) {
+		return fmt.Errorf("%w: window of %q doesn't fit %d transitions", ErrBadSnapshot, s.Name, mn.window)
+	}
+	mn.adjacencies = make([]MarkovEdge, 0, len(s.Edges))
+	mn.neighbors = make(map[string]int)
+	for _, edge := range s.Edges {
+		if _, ok := mn.neighbors[edge.Name]; ok || edge.Count <= 0 {
+			return fmt.Errorf("%w: edge from %q to %q", ErrBadSnapshot, s.Name, edge.Name)
+		}
+		mn.neighbors[edge.Name] = len(mn.adjacencies)
+		mn.adjacencies = append(mn.adjacencies, MarkovEdge{count: edge.Count, name: edge.Name})
+	}
+	mn.count = s.Count
+	mn.recent = append([]string(nil), s.Recent...)
+	mn.oldest = s.Oldest
+	mn.touched = s.Touched
+	mn.trim()
+	return nil
+}
@@ -0,0 +1,915 @@
+package markov
+
+import (
+	"errors"
+	"testing"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+func MakeAccesses(m *MarkovChain, files []string, id int) {
+	for _, file := range files {
+		m.RecordTransition(file, id)
+	}
+}
+
+func CheckPredictions(received []string, expected []string, t *testing.T) bool {
+	if len(received) != len(expected) {
+		t.Errorf("CheckPredictions FAILED. Received: size %v instead of size %v", len(received), len(expected))
+	}
+	for index, file := range received {
+		if (file != expected[index]) {
+			t.Errorf("CheckPredictions FAILED. Received: file %v instead of file %v", file, expected[index])
+			return false
+		}
+	}
+	return true
+}
+
+func TestChainSimple(t *testing.T) {
+	fmt.Printf("TestChainSimple ...\n")
+	failed := false
+
+	chain := MakeMarkovChain()
+
+	files := []string{
+		"a.png",
+		"b.png",
+		"c.png",
+		"a.png",
+		"b.png",
+		"c.png",
+	}
+
+	expected_predict := []string{
+		"b.png",
+		"c.png",
+	}
+
+	// execute transitions
+	MakeAccesses(chain, files, 1)
+
+	// first try predicting the next two values
+	short_predict, _ := chain.BatchPredict("a.png", 2)
+
+	if !CheckPredictions(short_predict, expected_predict, t) {
+		t.Errorf("short_predict failed")
+	}
+
+	// still expect it to only find the two files
+	long_predict, _ := chain.BatchPredict("a.png", 20)
+	
+	if !CheckPredictions(long_predict, expected_predict, t) {
+		t.Errorf("long_predict failed")
+	}
+
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestPredictErrors(t *testing.T) {
+	fmt.Printf("TestPredictErrors ...\n")
+	failed := false
+
+	chain := MakeMarkovChain()
+	MakeAccesses(chain, []string{"a.png", "b.png"}, 1)
+
+	if _, err := chain.BatchPredict("a.png", -1); !errors.Is(err, ErrInvalidPrefetchCount) {
+		t.Errorf("Expected ErrInvalidPrefetchCount, got %v", err)
+		failed = true
+	}
+
+	if _, err := chain.BatchPredict("c.png", 2); !errors.Is(err, ErrUnknownFile) {
+		t.Errorf("Expected ErrUnknownFile, got %v", err)
+		failed = true
+	}
+
+	// zero files is a valid (if useless) request
+	if predict, err := chain.BatchPredict("a.png", 0); err != nil || len(predict) != 0 {
+		t.Errorf("Expected no predictions and no error, got %v, %v", predict, err)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+// records a -> next, next -> a, n times
+func MakeCycles(m *MarkovChain, next string, n int) {
+	for i := 0; i < n; i++ {
+		MakeAccesses(m, []string{"a.png", next}, 1)
+	}
+}
+
+// how many a -> c transitions it takes, after 100 a -> b, for c to be predicted after a
+func TransitionsToAdapt(m *MarkovChain, limit int) int {
+	MakeCycles(m, "b.png", 100)
+	for i := 1; i <= limit; i++ {
+		MakeCycles(m, "c.png", 1)
+		if predict, err := m.BatchPredict("a.png", 1); err == nil && len(predict) > 0 && predict[0] == "c.png" {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestChainAdapts(t *testing.T) {
+	fmt.Printf("TestChainAdapts ...\n")
+	failed := false
+
+	// without forgetting, the old pattern wins until it is outnumbered
+	if n := TransitionsToAdapt(MakeMarkovChain(), 100); n != -1 {
+		t.Errorf("Expected the default chain to keep predicting b.png, adapted after %d", n)
+		failed = true
+	}
+
+	// the window forgets everything older than 10 transitions
+	if n := TransitionsToAdapt(MakeMarkovChainWithOptions(Options{Window: 10}), 100); n < 1 || n > 6 {
+		t.Errorf("Expected a window of 10 to adapt within 6 transitions, took %d", n)
+		failed = true
+	}
+
+	// decay halves the old pattern in log(0.5) / log(0.9) ~ 7 transitions
+	if n := TransitionsToAdapt(MakeMarkovChainWithOptions(Options{Decay: 0.9}), 100); n < 1 || n > 10 {
+		t.Errorf("Expected a decay of 0.9 to adapt within 10 transitions, took %d", n)
+		failed = true
+	}
+
+	// both together, each bounds the adaptation on its own
+	if n := TransitionsToAdapt(MakeMarkovChainWithOptions(Options{Decay: 0.99, Window: 10}), 100); n < 1 || n > 6 {
+		t.Errorf("Expected a window of 10 with decay to adapt within 6 transitions, took %d", n)
+		failed = true
+	}
+
+	// b.png is forgotten entirely once it leaves the window
+	chain := MakeMarkovChainWithOptions(Options{Window: 10})
+	MakeCycles(chain, "b.png", 100)
+	MakeCycles(chain, "c.png", 10)
+	node := chain.nodes["a.png"]
+	if _, ok := node.neighbors["b.png"]; ok || node.count != 10 || len(node.adjacencies) != 1 {
+		t.Errorf("Expected only 10 transitions to c.png to be counted, got %v over %v", node.adjacencies, node.count)
+		failed = true
+	}
+
+	// copies keep forgetting the same way
+	copied := chain.Copy()
+	MakeCycles(copied, "b.png", 10)
+	if predict, _ := copied.BatchPredict("a.png", 1); len(predict) == 0 || predict[0] != "b.png" {
+		t.Errorf("Expected the copy to adapt back to b.png, got %v", predict)
+		failed = true
+	}
+
+	// edges dropped by MaxDegree leave the window too, so every edge counts
+	// exactly its transitions in the window
+	node = MakeMarkovNode("a.png")
+	node.configure(Options{Window: 4, MaxDegree: 2})
+	rng := rand.New(rand.NewSource(1))
+	files := []string{"x", "y", "z", "x", "x"}
+	for i := 0; i < 200; i++ {
+		files = append(files, string(rune('v' + rng.Intn(5))))
+	}
+	for i, filename := range files {
+		node.RecordTransition(filename)
+		inWindow := make(map[string]float64)
+		for _, name := range node.recent {
+			inWindow[name]++
+		}
+		sum := 0.0
+		for _, edge := range node.adjacencies {
+			sum += edge.count
+			if edge.count != inWindow[edge.name] {
+				t.Errorf("Expected %v to count %v transitions after %v, got %v", edge.name, inWindow[edge.name], files[:i + 1], edge.count)
+				failed = true
+			}
+		}
+		if len(node.adjacencies) > 2 || sum != node.count || len(inWindow) != len(node.adjacencies) {
+			t.Errorf("Expected at most 2 edges counting the window %v, got %v over %v", node.recent, node.adjacencies, node.count)
+			failed = true
+		}
+		if failed {
+			break
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestHigherOrder(t *testing.T) {
+	fmt.Printf("TestHigherOrder ...\n")
+	failed := false
+
+	// what follows a.png depends on what came before it
+	pattern := []string{"x.png", "a.png", "b.png", "y.png", "a.png", "c.png"}
+	first := MakeMarkovChain()
+	second := MakeMarkovChainWithOptions(Options{Order: 2})
+	for i := 0; i < 10; i++ {
+		MakeAccesses(first, pattern, 1)
+		MakeAccesses(second, pattern, 1)
+	}
+
+	for _, context := range [][]string{{"x.png", "a.png"}, {"y.png", "a.png"}} {
+		MakeAccesses(first, context, 1)
+		MakeAccesses(second, context, 1)
+	}
+	// after y.png, a.png: only the second order chain knows c.png comes next
+	if predict, _ := second.BatchPredict("a.png", 2); len(predict) < 2 || !CheckPredictions(predict[:2], []string{"c.png", "x.png"}, t) {
+		t.Errorf("Expected c.png then x.png after y.png, a.png, got %v", predict)
+		failed = true
+	}
+	if predict, _ := first.BatchPredict("a.png", 2); len(predict) < 2 || predict[0] == predict[1] {
+		t.Errorf("Expected the first order chain to predict both b.png and c.png, got %v", predict)
+		failed = true
+	}
+
+	// other clients have their own history
+	MakeAccesses(second, []string{"x.png", "a.png"}, 2)
+	if predict, _ := second.BatchPredict("a.png", 1); len(predict) == 0 || predict[0] != "b.png" {
+		t.Errorf("Expected b.png after x.png, a.png, got %v", predict)
+		failed = true
+	}
+
+	// an unseen context backs off to a.png alone, which is followed by b.png or c.png
+	MakeAccesses(second, []string{"z.png", "a.png"}, 3)
+	if predict, _ := second.BatchPredict("a.png", 1); len(predict) == 0 || (predict[0] != "b.png" && predict[0] != "c.png") {
+		t.Errorf("Expected b.png or c.png after z.png, a.png, got %v", predict)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestChainBounds(t *testing.T) {
+	fmt.Printf("TestChainBounds ...\n")
+	failed := false
+
+	opts := Options{Order: 2, MaxNodes: 200, MaxDegree: 4}
+	bounded := MakeMarkovChainWithOptions(opts)
+	unbounded := MakeMarkovChainWithOptions(Options{Order: 2})
+
+	// a random stream over far more files than the chain can hold
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		filename := "file_" + strconv.Itoa(rng.Intn(5000)) + ".png"
+		bounded.RecordTransition(filename, i % 3)
+		unbounded.RecordTransition(filename, i % 3)
+
+		if i % 1000 == 0 {
+			if stats := bounded.Stats(); stats.Nodes > opts.MaxNodes || stats.Edges > opts.MaxNodes * opts.MaxDegree {
+				t.Errorf("Expected at most %d nodes of degree %d, got %+v", opts.MaxNodes, opts.MaxDegree, stats)
+				failed = true
+				break
+			}
+		}
+	}
+	for name, node := range bounded.nodes {
+		if len(node.adjacencies) > opts.MaxDegree || len(node.neighbors) != len(node.adjacencies) {
+			t.Errorf("Expected at most %d edges from %q, got %d", opts.MaxDegree, name, len(node.adjacencies))
+			failed = true
+			break
+		}
+	}
+	if bounded.contexts.len() > opts.MaxNodes {
+		t.Errorf("Expected contexts of pruned files to be dropped, %d are left", bounded.contexts.len())
+		failed = true
+	}
+
+	small, large := bounded.Stats(), unbounded.Stats()
+	if small.Bytes <= 0 || small.Bytes * 10 > large.Bytes {
+		t.Errorf("Expected the bounded chain to use a fraction of %d bytes, got %d", large.Bytes, small.Bytes)
+		failed = true
+	}
+
+	// recent patterns survive pruning
+	MakeAccesses(bounded, []string{"a.png", "b.png", "a.png", "b.png", "a.png", "b.png"}, 1)
+	if predict, err := bounded.BatchPredict("a.png", 1); err != nil || len(predict) == 0 || predict[0] != "b.png" {
+		t.Errorf("Expected b.png after a.png, got %v: %v", predict, err)
+		failed = true
+	}
+
+	// rebasing onto a larger model keeps the nodes the chain uses
+	rebased := MakeMarkovChainWithOptions(Options{MaxNodes: 8})
+	model := MakeMarkovChain()
+	for i := 0; i < 3; i++ {
+		MakeAccesses(rebased, []string{"x0", "x1", "x2", "x3"}, 1)
+	}
+	for i := 0; i < 20; i++ {
+		MakeAccesses(model, []string{"x0", "x1", "y" + strconv.Itoa(i)}, 2)
+	}
+	// the aggregate of this chain and another
+	model.Merge(rebased)
+	rebased.Rebase(model)
+	if predict, err := rebased.BatchPredict("x0", 3); err != nil || len(predict) != 3 || predict[0] != "x1" {
+		t.Errorf("Expected x1 first after x0 once rebased, got %v: %v", predict, err)
+		failed = true
+	}
+	for _, name := range []string{"x0", "x1", "x2", "x3"} {
+		if _, ok := rebased.nodes[name]; !ok {
+			t.Errorf("Expected %v to survive the rebase", name)
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestPredictProbable(t *testing.T) {
+	fmt.Printf("TestPredictProbable ...\n")
+	failed := false
+
+	chain := MakeMarkovChain()
+	MakeAccesses(chain, []string{"a.png", "b.png", "a.png", "b.png", "a.png", "b.png", "a.png", "c.png"}, 1)
+
+	predict, err := chain.BatchPredictProbable("a.png", 2, 0)
+	if err != nil || len(predict) < 2 || predict[0] != (Prediction{"b.png", 0.75}) || predict[1].Name != "c.png" || math.Abs(predict[1].Probability - 0.25) > 1e-9 {
+		t.Errorf("Expected b.png (0.75) and c.png (0.25), got %v: %v", predict, err)
+		failed = true
+	}
+
+	// unlikely files are left out
+	if predict, err := chain.BatchPredictProbable("a.png", 2, 0.5); err != nil || len(predict) != 1 || predict[0].Name != "b.png" {
+		t.Errorf("Expected only b.png above 0.5, got %v: %v", predict, err)
+		failed = true
+	}
+	if predict, err := chain.BatchPredictProbable("a.png", 2, 0.9); err != nil || len(predict) != 0 {
+		t.Errorf("Expected nothing above 0.9, got %v: %v", predict, err)
+		failed = true
+	}
+
+	if _, err := chain.BatchPredictProbable("a.png", -1, 0.5); !errors.Is(err, ErrInvalidPrefetchCount) {
+		t.Errorf("Expected ErrInvalidPrefetchCount, got %v", err)
+		failed = true
+	}
+	if _, err := chain.BatchPredictProbable("d.png", 1, 0.5); !errors.Is(err, ErrUnknownFile) {
+		t.Errorf("Expected ErrUnknownFile, got %v", err)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+// the most likely simple path from source to every other file, by trying them all
+func BrutePaths(m *MarkovChain, source string) map[string]float64 {
+	best := make(map[string]float64)
+	visited := map[string]bool{source: true}
+	var walk func(name string, probability float64)
+	walk = func(name string, probability float64) {
+		node, ok := m.nodes[name]
+		if !ok {
+			return
+		}
+		for _, transition := range node.adjacencies {
+			next := transition.name
+			if visited[next] {
+				continue
+			}
+			p := probability * transition.count / node.count
+			if p > best[next] {
+				best[next] = p
+			}
+			visited[next] = true
+			walk(next, p)
+			visited[next] = false
+		}
+	}
+	walk(source, 1)
+	return best
+}
+
+func TestLongPathsExhaustive(t *testing.T) {
+	fmt.Printf("TestLongPathsExhaustive ...\n")
+	failed := false
+
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200 && !failed; trial++ {
+		chain := MakeMarkovChain()
+		nFiles := 2 + rng.Intn(6)
+		for i := 0; i < 5 + rng.Intn(30); i++ {
+			chain.RecordTransition(strconv.Itoa(rng.Intn(nFiles)) + ".png", 1)
+		}
+
+		for source := range chain.nodes {
+			if source == "" {
+				continue
+			}
+			expected := BrutePaths(chain, source)
+			predict, err := chain.BatchPredictProbable(source, len(chain.nodes), 0)
+			if err != nil || len(predict) != len(expected) {
+				t.Errorf("Expected %d files from %v, got %v: %v", len(expected), source, predict, err)
+				failed = true
+				break
+			}
+			for i, prediction := range predict {
+				p, ok := expected[prediction.Name]
+				if !ok || math.IsInf(prediction.Probability, 0) || math.Abs(p - prediction.Probability) > 1e-9 {
+					t.Errorf("Expected %v with probability %v from %v, got %v", prediction.Name, p, source, prediction.Probability)
+					failed = true
+				}
+				if i > 0 && prediction.Probability > predict[i - 1].Probability + 1e-12 {
+					t.Errorf("Expected predictions from %v in likelihood order, got %v", source, predict)
+					failed = true
+				}
+			}
+
+			// fewer predictions are the most likely ones
+			n := rng.Intn(len(predict) + 1)
+			top, err := chain.BatchPredictProbable(source, n, 0)
+			if err != nil || len(top) != n {
+				t.Errorf("Expected %d files from %v, got %v: %v", n, source, top, err)
+				failed = true
+			}
+			for i := range top {
+				if top[i] != predict[i] {
+					t.Errorf("Expected %v to start %v", top, predict)
+					failed = true
+					break
+				}
+			}
+			if failed {
+				break
+			}
+		}
+	}
+
+	// ties are broken the same way every time
+	for i := 0; i < 20; i++ {
+		chain := MakeMarkovChain()
+		MakeAccesses(chain, []string{"a.png", "c.png", "a.png", "b.png", "a.png", "d.png"}, 1)
+		predict, _ := chain.BatchPredict("a.png", 3)
+		if !CheckPredictions(predict, []string{"b.png", "c.png", "d.png"}, t) {
+			failed = true
+			break
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestClientMixture(t *testing.T) {
+	fmt.Printf("TestClientMixture ...\n")
+	failed := false
+
+	chain := MakeMarkovChainWithOptions(Options{ClientWeight: 0.5})
+	// client 1 always goes from a to b, client 2 (three times as often) from a to c
+	for i := 0; i < 3; i++ {
+		MakeAccesses(chain, []string{"a.png", "b.png"}, 1)
+	}
+	for i := 0; i < 9; i++ {
+		MakeAccesses(chain, []string{"a.png", "c.png"}, 2)
+	}
+
+	// the global chain is dominated by client 2
+	if predict, _ := chain.BatchPredict("a.png", 2); !CheckPredictions(predict, []string{"c.png", "b.png"}, t) {
+		failed = true
+	}
+	// but client 1 still gets what it usually accesses, 0.5 * 1 + 0.5 * 0.25
+	predict, err := chain.BatchPredictClientProbable("a.png", 1, 2, 0)
+	if err != nil || len(predict) != 2 || predict[0].Name != "b.png" || math.Abs(predict[0].Probability - 0.625) > 1e-9 {
+		t.Errorf("Expected b.png (0.625) first for client 1, got %v: %v", predict, err)
+		failed = true
+	}
+	if predict, _ := chain.BatchPredictClient("a.png", 2, 2); !CheckPredictions(predict, []string{"c.png", "b.png"}, t) {
+		failed = true
+	}
+	if predict, _ := chain.BatchPredictClientProbable("a.png", 1, 2, 0.5); len(predict) != 1 || predict[0].Name != "b.png" {
+		t.Errorf("Expected only b.png above 0.5 for client 1, got %v", predict)
+		failed = true
+	}
+
+	// clients that never accessed the file get the global predictions
+	if predict, _ := chain.BatchPredictClient("a.png", 3, 2); !CheckPredictions(predict, []string{"c.png", "b.png"}, t) {
+		failed = true
+	}
+	if _, err := chain.BatchPredictClient("d.png", 1, 2); !errors.Is(err, ErrUnknownFile) {
+		t.Errorf("Expected ErrUnknownFile, got %v", err)
+		failed = true
+	}
+
+	// client chains survive copies and rebases, and count towards the chain's size
+	copied := chain.Copy()
+	copied.Rebase(MakeMarkovChain())
+	if predict, _ := copied.BatchPredictClient("a.png", 1, 1); !CheckPredictions(predict, []string{"b.png"}, t) {
+		failed = true
+	}
+	single := MakeMarkovChain()
+	for i := 0; i < 3; i++ {
+		MakeAccesses(single, []string{"a.png", "b.png"}, 1)
+	}
+	for i := 0; i < 9; i++ {
+		MakeAccesses(single, []string{"a.png", "c.png"}, 2)
+	}
+	if mixed := chain.Stats(); mixed.Nodes <= single.Stats().Nodes || mixed.Bytes <= single.Stats().Bytes {
+		t.Errorf("Expected client chains in the stats, got %+v", mixed)
+		failed = true
+	}
+
+	// the global chain predicts from the client's own context, even when another
+	// client accessed the file after different files since
+	ordered := MakeMarkovChainWithOptions(Options{Order: 2, ClientWeight: 0.2})
+	for i := 0; i < 3; i++ {
+		MakeAccesses(ordered, []string{"x.png", "a.png", "b.png"}, 1)
+		MakeAccesses(ordered, []string{"y.png", "a.png", "c.png"}, 2)
+	}
+	MakeAccesses(ordered, []string{"x.png", "a.png"}, 1)
+	MakeAccesses(ordered, []string{"y.png", "a.png"}, 2)
+	if predict, _ := ordered.BatchPredictClientProbable("a.png", 1, 1, 0); len(predict) != 1 || predict[0].Name != "b.png" || predict[0].Probability != 1 {
+		t.Errorf("Expected b.png for certain after x.png, a.png, got %v", predict)
+		failed = true
+	}
+
+	// only the chains of the most recently active clients are kept
+	few := MakeMarkovChainWithOptions(Options{ClientWeight: 0.5, MaxClients: 2})
+	for id := 1; id <= 3; id++ {
+		MakeAccesses(few, []string{"a.png", "b.png"}, id)
+	}
+	MakeAccesses(few, []string{"a.png"}, 2)
+	MakeAccesses(few, []string{"a.png"}, 4)
+	if len(few.clients) != 2 || few.clients[2] == nil || few.clients[4] == nil {
+		t.Errorf("Expected only the chains of clients 2 and 4 to be kept, kept %v", len(few.clients))
+		failed = true
+	}
+	// and chains bounded by MaxNodes bound their clients too
+	bounded := MakeMarkovChainWithOptions(Options{ClientWeight: 0.5, MaxNodes: 10})
+	for id := 0; id < 100; id++ {
+		MakeAccesses(bounded, []string{"a.png", "b.png"}, id)
+	}
+	if len(bounded.clients) != defaultMaxClients {
+		t.Errorf("Expected %d client chains, got %d", defaultMaxClients, len(bounded.clients))
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+// every prediction of every file and client, to compare chains
+func AllPredictions(m *MarkovChain, clients int) map[string][]Prediction {
+	all := make(map[string][]Prediction)
+	m.mu.Lock()
+	names := make([]string, 0, len(m.nodes))
+	for name := range m.nodes {
+		names = append(names, lastFile(name))
+	}
+	m.mu.Unlock()
+	for _, name := range names {
+		for id := 0; id < clients; id++ {
+			predict, err := m.BatchPredictClientProbable(name, id, 5, 0)
+			if err == nil {
+				all[name + "/" + strconv.Itoa(id)] = predict
+			}
+		}
+	}
+	return all
+}
+
+func TestSnapshot(t *testing.T) {
+	fmt.Printf("TestSnapshot ...\n")
+	failed := false
+
+	rng := rand.New(rand.NewSource(2))
+	opts := Options{Order: 2, Decay: 0.99, Window: 20, MaxNodes: 40, MaxDegree: 4, ClientWeight: 0.3}
+	chain := MakeMarkovChainWithOptions(opts)
+	for i := 0; i < 2000; i++ {
+		chain.RecordTransition(strconv.Itoa(rng.Intn(30)) + ".png", rng.Intn(3))
+	}
+
+	dir := t.TempDir()
+	for _, path := range []string{filepath.Join(dir, "chain.json"), filepath.Join(dir, "chain.bin")} {
+		if err := chain.SaveFile(path); err != nil {
+			t.Errorf("Could not save %v: %v", path, err)
+			failed = true
+			continue
+		}
+		loaded, err := LoadFile(path)
+		if err != nil {
+			t.Errorf("Could not load %v: %v", path, err)
+			failed = true
+			continue
+		}
+		if loaded.Options() != opts || !reflect.DeepEqual(loaded.Stats(), chain.Stats()) {
+			t.Errorf("Expected %+v with %+v from %v, got %+v with %+v", opts, chain.Stats(), path, loaded.Options(), loaded.Stats())
+			failed = true
+		}
+		if before, after := AllPredictions(chain, 3), AllPredictions(loaded, 3); len(before) == 0 || !reflect.DeepEqual(before, after) {
+			t.Errorf("Expected the same predictions after loading %v", path)
+			failed = true
+		}
+
+		// the chains keep learning the same way, windows and last accesses included
+		copied := chain.Copy()
+		next := rand.New(rand.NewSource(3))
+		for i := 0; i < 500; i++ {
+			filename, id := strconv.Itoa(next.Intn(30)) + ".png", next.Intn(3)
+			copied.RecordTransition(filename, id)
+			loaded.RecordTransition(filename, id)
+		}
+		if before, after := AllPredictions(copied, 3), AllPredictions(loaded, 3); !reflect.DeepEqual(before, after) {
+			t.Errorf("Expected the same predictions after learning more from %v", path)
+			failed = true
+		}
+	}
+
+	// the same chain always has the same snapshot
+	first, _ := chain.MarshalJSON()
+	second, _ := chain.Copy().MarshalJSON()
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected identical snapshots of identical chains")
+		failed = true
+	}
+
+	// snapshots of other versions, or anything else, are refused
+	content, _ := chain.MarshalBinary()
+	content[len(snapshotMagic)] = SnapshotVersion + 1
+	bad := [][]byte{content, []byte("MKVC"), []byte("{}"), []byte(`{"version": 2}`), []byte("not a chain")}
+	for _, b := range bad {
+		var err error
+		if b[0] == '{' || b[0] == 'n' {
+			err = MakeMarkovChain().UnmarshalJSON(b)
+		} else {
+			err = MakeMarkovChain().UnmarshalBinary(b)
+		}
+		if !errors.Is(err, ErrBadSnapshot) {
+			t.Errorf("Expected ErrBadSnapshot for %q, got %v", b, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+// edge counts of every node with edges
+func Counts(m *MarkovChain) map[string]map[string]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]map[string]float64)
+	for name, node := range m.nodes {
+		for _, edge := range node.adjacencies {
+			if counts[name] == nil {
+				counts[name] = make(map[string]float64)
+			}
+			counts[name][edge.name] = edge.count
+		}
+	}
+	return counts
+}
+
+func SameCounts(a map[string]map[string]float64, b map[string]map[string]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, edges := range a {
+		if len(edges) != len(b[name]) {
+			return false
+		}
+		for next, count := range edges {
+			if other, ok := b[name][next]; !ok || math.Abs(count - other) > 1e-9 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestMergeDiff(t *testing.T) {
+	fmt.Printf("TestMergeDiff ...\n")
+	failed := false
+
+	// every client sticks to one of 4 caches, one chain sees everything
+	rng := rand.New(rand.NewSource(4))
+	opts := Options{}
+	single := MakeMarkovChainWithOptions(opts)
+	chains := make([]*MarkovChain, 4)
+	for i := range chains {
+		chains[i] = MakeMarkovChainWithOptions(opts)
+	}
+	for i := 0; i < 5000; i++ {
+		filename, id := strconv.Itoa(rng.Intn(20)) + ".png", rng.Intn(12)
+		single.RecordTransition(filename, id)
+		chains[id % 4].RecordTransition(filename, id)
+	}
+
+	merged := MakeMarkovChainWithOptions(opts)
+	for _, chain := range chains {
+		merged.Merge(chain)
+	}
+	if !SameCounts(Counts(merged), Counts(single)) {
+		t.Errorf("Expected merging every cache's chain to count every transition once")
+		failed = true
+	}
+	if !reflect.DeepEqual(AllPredictions(merged, 1), AllPredictions(single, 1)) {
+		t.Errorf("Expected merged chains to predict like a chain that saw everything")
+		failed = true
+	}
+
+	// subtracting a chain undoes merging it
+	rest := MakeMarkovChainWithOptions(opts)
+	for _, chain := range chains[1:] {
+		rest.Merge(chain)
+	}
+	merged.Subtract(chains[0])
+	if !SameCounts(Counts(merged), Counts(rest)) {
+		t.Errorf("Expected subtracting a chain to undo merging it")
+		failed = true
+	}
+
+	// decayed counts summed and taken out again only differ by rounding, which isn't a change
+	decayed := MakeMarkovChainWithOptions(Options{Decay: 0.9})
+	other := MakeMarkovChainWithOptions(Options{Decay: 0.7})
+	for i := 0; i < 200; i++ {
+		decayed.RecordTransition(strconv.Itoa(rng.Intn(5)) + ".png", 0)
+		other.RecordTransition(strconv.Itoa(rng.Intn(5)) + ".png", 0)
+	}
+	sum := MakeMarkovChain()
+	sum.Merge(decayed)
+	sum.Merge(other)
+	sum.Subtract(other)
+	if changes := decayed.Diff(sum); changes.Stats().Edges != 0 {
+		t.Errorf("Expected no changes once the other chain is taken out, got %+v", changes.Stats())
+		failed = true
+	}
+
+	// shipping only the changes keeps an aggregate equal to the chain, even
+	// as it forgets old transitions and nodes
+	chain := MakeMarkovChainWithOptions(Options{Decay: 0.95, Window: 30, MaxNodes: 15, MaxDegree: 5})
+	aggregate := MakeMarkovChain()
+	shipped := MakeMarkovChain()
+	for sync := 0; sync < 20 && !failed; sync++ {
+		for i := 0; i < 100; i++ {
+			chain.RecordTransition(strconv.Itoa(rng.Intn(20 + sync)) + ".png", rng.Intn(3))
+		}
+		current := chain.Copy()
+		changes := current.Diff(shipped)
+		aggregate.Merge(changes)
+		shipped = current
+
+		if !SameCounts(Counts(aggregate), Counts(current)) {
+			t.Errorf("Expected the aggregate to match the chain after sync %d", sync)
+			failed = true
+		}
+	}
+
+	// taking the chain back out leaves no edges, nor nodes that only had them
+	aggregate.Subtract(chain)
+	if stats := aggregate.Stats(); stats.Edges != 0 {
+		t.Errorf("Expected subtracting the chain from its aggregate to leave no edges, got %+v", stats)
+		failed = true
+	}
+	for name := range Counts(chain) {
+		if _, ok := aggregate.nodes[name]; ok && name != "" {
+			t.Errorf("Expected %q to be dropped once it has no edges", name)
+			failed = true
+			break
+		}
+	}
+
+	// nothing changed, nothing to ship
+	if changes := chain.Diff(chain.Copy()); changes.Stats().Edges != 0 {
+		t.Errorf("Expected no changes between a chain and its copy, got %+v", changes.Stats())
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+func TestConcurrentChain(t *testing.T) {
+	fmt.Printf("TestConcurrentChain ...\n")
+	failed := false
+
+	chain := MakeMarkovChain()
+	bounded := MakeMarkovChainWithOptions(Options{Order: 2, MaxNodes: 30, MaxDegree: 4, ClientWeight: 0.5})
+	const clients, transitions = 8, 2000
+
+	var wg sync.WaitGroup
+	for id := 0; id < clients; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(id)))
+			for i := 0; i < transitions; i++ {
+				filename := strconv.Itoa(rng.Intn(50)) + ".png"
+				chain.RecordTransition(filename, id)
+				bounded.RecordTransition(filename, id)
+			}
+		}(id)
+	}
+	// predictions, copies and syncs run alongside
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 3; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			aggregate := MakeMarkovChain()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				filename := strconv.Itoa(i % 50) + ".png"
+				chain.BatchPredict(filename, 5)
+				bounded.BatchPredictClientProbable(filename, i % clients, 5, 0.1)
+				if i % 50 == 0 {
+					aggregate.Merge(bounded.Copy())
+					bounded.Stats()
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	// every transition adds one to the node it leaves, none may be lost
+	total := 0.0
+	chain.mu.Lock()
+	for _, node := range chain.nodes {
+		total += node.count
+	}
+	chain.mu.Unlock()
+	if total != clients * transitions {
+		t.Errorf("Expected %d transitions, counted %v", clients * transitions, total)
+		failed = true
+	}
+	if stats := bounded.Stats(); stats.Nodes > 30 * (clients + 1) {
+		t.Errorf("Expected at most 30 nodes in each chain, got %+v", stats)
+		failed = true
+	}
+
+	if failed {
+		fmt.Printf("\t... FAILED\n")
+	} else {
+		fmt.Printf("\t... PASSED\n")
+	}
+}
+
+// run with -cpu 1,2,4,8 to see how throughput scales with goroutines
+// every goroutine is a client recording transitions, predicting after every tenth one
+func BenchmarkChainParallel(b *testing.B) {
+	files := make([]string, 200)
+	for i := range files {
+		files[i] = strconv.Itoa(i) + ".png"
+	}
+	chain := MakeMarkovChainWithOptions(Options{Order: 2})
+	rng := rand.New(rand.NewSource(5))
+	for i := 0; i < 20000; i++ {
+		chain.RecordTransition(files[rng.Intn(len(files))], rng.Intn(16))
+	}
+
+	var clients int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := int(atomic.AddInt64(&clients, 1))
+		rng := rand.New(rand.NewSource(int64(id)))
+		for i := 0; pb.Next(); i++ {
+			filename := files[rng.Intn(len(files))]
+			chain.RecordTransition(filename, id)
+			if i % 10 == 0 {
+				chain.BatchPredict(filename, 10)
+			}
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package markov
+
+import (
+	"sync"
+)
+
+// number of shards of a shardedMap, concurrent accesses to different keys
+// only wait for each other one time in nShards
+const nShards = 16
+
+// a map of sequences of files split into shards with a lock each
+// clients record transitions concurrently, so their histories shouldn't share one lock
+type shardedMap[K comparable] struct {
+	shards			[nShards]shard[K]
+	hash			func(K) uint32
+}
+
+type shard[K comparable] struct {
+	mu				sync.Mutex
+	items			map[K][]string
+}
+
+func makeShardedMap[K comparable](hash func(K) uint32) *shardedMap[K] {
+	s := &shardedMap[K]{hash: hash}
+	for i := range s.shards {
+		s.shards[i].items = make(map[K][]string)
+	}
+	return s
+}
+
+// the shards of client histories, by client ID
+func makeHistories() *shardedMap[int] {
+	return makeShardedMap(func(id int) uint32 {
+		return uint32(id)
+	})
+}
+
+// the shards of contexts, by filename (FNV-1a)
+func makeContexts() *shardedMap[string] {
+	return makeShardedMap(func(filename string) uint32 {
+		h := uint32(2166136261)
+		for i := 0; i < len(filename); i++ {
+			h ^= uint32(filename[i])
+			h *= 16777619
+		}
+		return h
+	})
+}
+
+func (s *shardedMap[K]) shard(key K) *shard[K] {
+	return &s.shards[s.hash(key) % nShards]
+}
+
+// sequences are never modified in place, so they are shared rather than copied
+func (s *shardedMap[K]) get(key K) []string {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.items[key]
+}
+
+func (s *shardedMap[K]) set(key K, files []string) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.items[key] = files
+}
+
+func (s *shardedMap[K]) delete(key K) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.items, key)
+}
+
+// calls f on every item, one shard at a time
+// f must not use s
+func (s *shardedMap[K]) each(f func(K, []string)) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key, files := range sh.items {
+			f(key, files)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+func (s *shardedMap[K]) len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += len(sh.items)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// a copy of the map, sharing its sequences
+func (s *shardedMap[K]) copy() *shardedMap[K] {
+	c := makeShardedMap(s.hash)
+	s.each(func(key K, files []string) {
+		c.shard(key).items[key] = files
+	})
+	return c
+}
@@ -0,0 +1,213 @@
+package markov
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+/********************************************************
+Snapshot API
+Saves everything a chain learned, so it survives restarts
+Snapshots hold the options, nodes and edge counts, the last accesses of
+every client and the chains of every client
+m.MarshalJSON() ([]byte, error), m.UnmarshalJSON(content []byte) error
+ - JSON snapshot, {"version": SnapshotVersion, ...}
+m.MarshalBinary() ([]byte, error), m.UnmarshalBinary(content []byte) error
+ - binary snapshot, snapshotMagic, then the version byte, then gob
+m.SaveFile(path string) error
+ - JSON snapshot if path ends in .json, binary otherwise
+LoadFile(path string) (*MarkovChain, error)
+ - chain saved in either format
+Unmarshaling replaces the whole chain, options included
+Fails with ErrBadSnapshot if the content isn't a snapshot of a known version
+********************************************************/
+
+var ErrBadSnapshot = errors.New("invalid markov chain snapshot")
+
+// bumped whenever snapshots change, so old ones are refused instead of misread
+const SnapshotVersion = 1
+
+// starts every binary snapshot
+const snapshotMagic = "MKVC"
+
+type snapshot struct {
+	Version			int						`json:"version"`
+	Options			Options					`json:"options"`
+	Tick			int64					`json:"tick"`
+	Nodes			[]nodeSnapshot			`json:"nodes"`
+	History			map[int][]string		`json:"history,omitempty"`
+	Contexts		map[string][]string		`json:"contexts,omitempty"`
+	Clients			map[int]*snapshot		`json:"clients,omitempty"`
+}
+
+type nodeSnapshot struct {
+	Name			string					`json:"name"`
+	Count			float64					`json:"count"`
+	Edges			[]edgeSnapshot			`json:"edges,omitempty"`
+	Recent			[]string				`json:"recent,omitempty"`
+	Oldest			int						`json:"oldest,omitempty"`
+	Touched			int64					`json:"touched,omitempty"`
+}
+
+type edgeSnapshot struct {
+	Name			string					`json:"name"`
+	Count			float64					`json:"count"`
+}
+
+func (m *MarkovChain) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.snapshot())
+}
+
+func (m *MarkovChain) UnmarshalJSON(content []byte) error {
+	var s snapshot
+	if err := json.Unmarshal(content, &s); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	return m.restore(&s)
+}
+
+func (m *MarkovChain) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(snapshotMagic)
+	buf.WriteByte(SnapshotVersion)
+	if err := gob.NewEncoder(&buf).Encode(m.snapshot()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *MarkovChain) UnmarshalBinary(content []byte) error {
+	if !bytes.HasPrefix(content, []byte(snapshotMagic)) || len(content) <= len(snapshotMagic) {
+		return fmt.Errorf("%w: not a binary snapshot", ErrBadSnapshot)
+	}
+	if version := int(content[len(snapshotMagic)]); version != SnapshotVersion {
+		return fmt.Errorf("%w: version %d, expected %d", ErrBadSnapshot, version, SnapshotVersion)
+	}
+	var s snapshot
+	if err := gob.NewDecoder(bytes.NewReader(content[len(snapshotMagic) + 1:])).Decode(&s); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	return m.restore(&s)
+}
+
+// writes a snapshot of the chain to path, as JSON if path ends in .json
+func (m *MarkovChain) SaveFile(path string) error {
+	var content []byte
+	var err error
+	if strings.HasSuffix(path, ".json") {
+		content, err = m.MarshalJSON()
+	} else {
+		content, err = m.MarshalBinary()
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// reads a chain from a snapshot file of either format
+func LoadFile(path string) (*MarkovChain, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := MakeMarkovChain()
+	if bytes.HasPrefix(content, []byte(snapshotMagic)) {
+		err = m.UnmarshalBinary(content)
+	} else {
+		err = m.UnmarshalJSON(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %v: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *MarkovChain) snapshot() *snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := &snapshot{
+		Version: SnapshotVersion,
+		Options: m.opts,
+		Tick: atomic.LoadInt64(&m.tick),
+		History: make(map[int][]string),
+		Contexts: make(map[string][]string),
+	}
+	// histories are never modified in place, so they can be shared
+	m.history.each(func(id int, history []string) {
+		s.History[id] = history
+	})
+	m.contexts.each(func(filename string, context []string) {
+		s.Contexts[filename] = context
+	})
+	// in order, so the same chain always has the same JSON snapshot
+	names := make([]string, 0, len(m.nodes))
+	for name := range m.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.Nodes = append(s.Nodes, m.nodes[name].snapshot())
+	}
+	if m.clients != nil {
+		s.Clients = make(map[int]*snapshot)
+		for id, client := range m.clients {
+			s.Clients[id] = client.snapshot()
+		}
+	}
+	return s
+}
+
+// replaces the whole chain with what s holds
+func (m *MarkovChain) restore(s *snapshot) error {
+	if s.Version != SnapshotVersion {
+		return fmt.Errorf("%w: version %d, expected %d", ErrBadSnapshot, s.Version, SnapshotVersion)
+	}
+
+	c := MakeMarkovChainWithOptions(s.Options)
+	c.tick = s.Tick
+	for _, ns := range s.Nodes {
+		node := c.makeNode(ns.Name)
+		if err := node.restore(ns); err != nil {
+			return err
+		}
+		c.nodes[ns.Name] = node
+	}
+	for id, history := range s.History {
+		if len(history) == 0 {
+			return fmt.Errorf("%w: empty history of client %d", ErrBadSnapshot, id)
+		}
+		c.history.set(id, history)
+	}
+	for filename, context := range s.Contexts {
+		c.contexts.set(filename, context)
+	}
+	for id, cs := range s.Clients {
+		if c.clients == nil || cs == nil {
+			return fmt.Errorf("%w: chain of client %d", ErrBadSnapshot, id)
+		}
+		client := MakeMarkovChain()
+		if err := client.restore(cs); err != nil {
+			return err
+		}
+		c.clients[id] = client
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes = c.nodes
+	m.history = c.history
+	m.contexts = c.contexts
+	m.clients = c.clients
+	m.opts = c.opts
+	atomic.StoreInt64(&m.tick, c.tick)
+	return nil
+}